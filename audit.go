@@ -0,0 +1,79 @@
+package chaos
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// AuditRecord describes a call to an endpoint which might have made changes,
+// for API.Audit.
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	// Login is who made the call: the control login, or the account number
+	// if there's no control login.
+	Login string `json:"login"`
+	Path  string `json:"path"`
+	// Params is the request's parameters, without any credentials.
+	Params url.Values `json:"params"`
+	// Response is the API's response, which may include a reference for the
+	// change, such as an order number.
+	Response json.RawMessage `json:"response,omitempty"`
+	// Error is why the call failed, if it did. The change may still have
+	// been made if the response was lost.
+	Error string `json:"error,omitempty"`
+}
+
+// audit reports a call to path to api.Audit if it's set and path isn't one of
+// the known Endpoints, which don't make changes. Nothing is reported in
+// read-only mode, which refuses such calls.
+func (api API) audit(ctx context.Context, path string, params url.Values, resp []byte, err error) {
+	if api.Audit == nil || api.ReadOnly {
+		return
+	}
+	if _, ok := LookupEndpoint(path); ok {
+		return
+	}
+	login := api.login
+	if api.Credentials != nil {
+		if auth, err := api.Credentials.Credentials(ctx); err == nil {
+			login = auth.form()
+		}
+	}
+	r := AuditRecord{
+		Time:   time.Now(),
+		Login:  login.Get("control_login"),
+		Path:   path,
+		Params: url.Values{},
+	}
+	if r.Login == "" {
+		r.Login = login.Get("account_number")
+	}
+	for k, v := range params {
+		if !credentialParam(k) {
+			r.Params[k] = v
+		}
+	}
+	if json.Valid(resp) {
+		r.Response = resp
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	api.Audit(r)
+}
+
+// NewAuditLog returns a function for API.Audit which writes each record to w
+// as a line of JSON. It's safe to share between APIs.
+func NewAuditLog(w io.Writer) func(AuditRecord) {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return func(r AuditRecord) {
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(r)
+	}
+}
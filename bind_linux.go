@@ -0,0 +1,18 @@
+package chaos
+
+import "syscall"
+
+// bindToDevice returns a net.Dialer Control function which binds sockets to
+// the network interface iface.
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		cerr := c.Control(func(fd uintptr) {
+			err = syscall.BindToDevice(int(fd), iface)
+		})
+		if cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
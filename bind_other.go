@@ -0,0 +1,16 @@
+//go:build !linux
+
+package chaos
+
+import (
+	"errors"
+	"syscall"
+)
+
+// bindToDevice returns a net.Dialer Control function which fails, because
+// binding to an interface is only supported on Linux.
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return errors.New("binding connections to an interface is only supported on Linux")
+	}
+}
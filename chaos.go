@@ -2,14 +2,16 @@
 package chaos
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -18,7 +20,49 @@ const defaultEndpoint = "https://chaos2.aa.net.uk"
 // API provides the accessors for querying the CHAOS service.
 type API struct {
 	Endpoint string
-	login    url.Values
+	// Fallback is a list of additional endpoints, such as a mirror or proxy,
+	// which are tried in order when Endpoint is unreachable.
+	Fallback []string
+	// Retries is how many times a request is retried on each endpoint,
+	// before failing over to the next, when the endpoint can't be reached or
+	// returns a server error. Retries wait 250ms, doubling each time up to 30s.
+	//
+	// Only requests to the known Endpoints are retried, or sent to the
+	// Fallback endpoints. Other endpoints called with Do might make
+	// changes, such as buying a top-up, and the API has no idempotency keys
+	// to stop a retry after a timeout from making the change twice.
+	Retries int
+	// RetryBudget, if set, limits the retries made by requests from this API
+	// and any others sharing the budget.
+	RetryBudget *RetryBudget
+	// Timeout limits the total time taken by a request to each endpoint.
+	// The default is 10 seconds.
+	Timeout time.Duration
+	// Transport makes HTTP requests. If nil, a shared transport from
+	// NewTransport with the default settings is used.
+	Transport http.RoundTripper
+	// Validators, if set, keeps the validators the API returns for each
+	// request and sends them with later identical requests. If the API then
+	// reports the data hasn't changed, ErrNotModified is returned instead of
+	// the response, so the caller can keep using what it already has.
+	Validators *Validators
+	// OnDecodeWarning, if set, is called for each field in a response which
+	// couldn't be decoded, such as a number given as an empty string. The
+	// field is left as its zero value rather than failing the response.
+	OnDecodeWarning func(DecodeWarning)
+	// Credentials, if set, provides the credentials for each request instead
+	// of those passed to New, so that rotated credentials are picked up.
+	Credentials CredentialProvider
+	// ReadOnly refuses requests to anything but the known Endpoints, which
+	// don't change anything, with ErrReadOnly. It stops credentials used for
+	// monitoring from being used to buy top-ups, place orders or change
+	// settings, even by mistake.
+	ReadOnly bool
+	// Audit, if set, is called after each call with Do to an endpoint which
+	// isn't known, and so might have made changes, whether or not it
+	// succeeded. NewAuditLog writes the records to a file.
+	Audit func(AuditRecord)
+	login url.Values
 }
 
 // New takes an Auth with API credentials and returns an API object.
@@ -56,57 +100,269 @@ func (a Auth) form() url.Values {
 	return f
 }
 
-func userAgent() string {
-	return fmt.Sprintf("chaos-go (%s; %s; %s) github.com/jamesog/aaisp-chaos",
-		runtime.GOOS,
-		runtime.GOARCH,
-		runtime.Version(),
-	)
+// userAgent is sent with every request.
+var userAgent = fmt.Sprintf("chaos-go (%s; %s; %s) github.com/jamesog/aaisp-chaos",
+	runtime.GOOS,
+	runtime.GOARCH,
+	runtime.Version(),
+)
+
+// bufPool holds buffers for reading response bodies, so that frequent polling
+// doesn't allocate a new buffer for every response.
+var bufPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
 }
 
-func (api API) makeRequest(url string) ([]byte, error) {
+// maxPooledBuf is the largest buffer returned to bufPool, so that one unusually
+// large response doesn't pin its memory.
+const maxPooledBuf = 1 << 20
+
+// makeRequest sends the request to each endpoint in turn until one responds,
+// and passes the response body to fn. The body is only valid until fn returns.
+// Retries and failover only happen when an endpoint can't be reached or
+// returns a server error; any other response is returned to the caller.
+// Neither happens for unknown paths, which might not be safe to repeat.
+func (api API) makeRequest(ctx context.Context, path string, params url.Values, fn func(body []byte) error) error {
+	_, known := LookupEndpoint(path)
+	if api.ReadOnly && !known {
+		return fmt.Errorf("%s: %w", path, ErrReadOnly)
+	}
+	form := url.Values{}
+	for k, v := range params {
+		form[k] = v
+	}
+	login := api.login
+	if api.Credentials != nil {
+		auth, err := api.Credentials.Credentials(ctx)
+		if err != nil {
+			return fmt.Errorf("getting credentials: %w", err)
+		}
+		login = auth.form()
+	}
+	for k, v := range login {
+		form[k] = v
+	}
+	encoded := form.Encode()
+	// Validators are specific to the endpoint which returned them, and
+	// don't include the credentials.
+	key := path + "?" + params.Encode()
+
+	buf := bufPool.Get().(*bytes.Buffer)
+	defer func() {
+		if buf.Cap() <= maxPooledBuf {
+			bufPool.Put(buf)
+		}
+	}()
+
+	var err error
+	for _, endpoint := range append([]string{api.Endpoint}, api.Fallback...) {
+		for attempt := 1; ; attempt++ {
+			buf.Reset()
+			err = api.request(ctx, endpoint, path, endpoint+key, encoded, buf)
+			if !IsRetryable(err) || !api.shouldRetry(ctx, path, attempt) {
+				break
+			}
+		}
+		if !known || !IsRetryable(err) || ctx.Err() != nil {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return fn(buf.Bytes())
+}
+
+// request sends the encoded form to endpoint and reads the response body into
+// buf.
+func (api API) request(ctx context.Context, endpoint, path, key, form string, buf *bytes.Buffer) error {
+	transport := api.Transport
+	if transport == nil {
+		transport = defaultTransport
+	}
+	timeout := api.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
 	client := &http.Client{
-		Timeout: 10 * time.Second,
+		Timeout:   timeout,
+		Transport: transport,
 	}
 
-	req, err := http.NewRequest("POST", api.Endpoint+url, strings.NewReader(api.login.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint+path, strings.NewReader(form))
 	if err != nil {
-		return nil, err
+		return err
 	}
-	req.Header.Set("User-Agent", userAgent())
+	req.Header.Set("User-Agent", userAgent)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if api.Validators != nil {
+		api.Validators.set(req, key)
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return &APIError{Err: err}
 	}
-
-	body, err := ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
-	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %w", err)
+	if resp.StatusCode == http.StatusNotModified {
+		return ErrNotModified
+	}
+
+	if resp.ContentLength > 0 && resp.ContentLength <= maxPooledBuf {
+		buf.Grow(int(resp.ContentLength))
+	}
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		return &APIError{Err: fmt.Errorf("error reading response body: %w", err)}
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("bad response code: %d", resp.StatusCode)
+		return &APIError{StatusCode: resp.StatusCode}
+	}
+	if api.Validators != nil {
+		api.Validators.store(key, resp.Header)
 	}
 
-	return body, nil
+	return nil
 }
 
-// The API returns timestamps in the format "YYYY-mm-dd HH:mm:ss" rather than RFC3389.
+// Do calls an arbitrary API endpoint, such as "/broadband/info", and returns
+// the raw JSON response. The authentication credentials are sent along with
+// params, which may be nil.
 //
-// Use a custom type to unmarshal JSON to this format.
-type chaosTime struct {
+// Do is an escape hatch for endpoints which the package doesn't yet model. If
+// the API returns an error message, it is returned as an APIError along with
+// the response.
+func (api API) Do(path string, params url.Values) ([]byte, error) {
+	return api.DoContext(context.Background(), path, params)
+}
+
+// DoContext is like Do, but gives up when ctx is done.
+func (api API) DoContext(ctx context.Context, path string, params url.Values) ([]byte, error) {
+	var resp []byte
+	err := api.makeRequest(ctx, path, params, func(body []byte) error {
+		// body belongs to the buffer pool, so the caller gets a copy.
+		resp = append([]byte(nil), body...)
+		return nil
+	})
+	if err != nil {
+		api.audit(ctx, path, params, nil, err)
+		return nil, err
+	}
+	r := struct {
+		Error string `json:"error"`
+	}{}
+	if err := json.Unmarshal(resp, &r); err == nil && r.Error != "" {
+		err := &APIError{Message: r.Error}
+		api.audit(ctx, path, params, resp, err)
+		return resp, err
+	}
+	api.audit(ctx, path, params, resp, nil)
+	return resp, nil
+}
+
+// call requests path and decodes the response's key field into a T. Every
+// CHAOS response is a JSON object with the data under a command-specific key,
+// or an error message under "error". name identifies the call in errors.
+func call[T any](ctx context.Context, api API, name, path, key string, params url.Values) (T, error) {
+	var v T
+	err := api.makeRequest(ctx, path, params, func(body []byte) error {
+		err := decodeField(body, key, &v)
+		var apiErr *APIError
+		if err != nil && !errors.As(err, &apiErr) {
+			return fmt.Errorf("%s JSON decode: %w", name, err)
+		}
+		return err
+	})
+	return v, err
+}
+
+// decodeField decodes the key field of the JSON object in body into v,
+// without copying the rest of the object. If the object has a non-empty
+// "error" field, it's returned as an APIError.
+func decodeField(body []byte, key string, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if t, err := dec.Token(); err != nil {
+		return err
+	} else if t != json.Delim('{') {
+		return fmt.Errorf("expected a JSON object, got %v", t)
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case key:
+			err = dec.Decode(v)
+		case "error":
+			var msg string
+			if err = dec.Decode(&msg); err == nil && msg != "" {
+				return &APIError{Message: msg}
+			}
+		default:
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// timeFormat is the format of timestamps returned by the API.
+const timeFormat = "2006-01-02 15:04:05"
+
+// Time is a timestamp returned by the API.
+//
+// The API returns timestamps in the format "YYYY-mm-dd HH:mm:ss" rather than RFC3339.
+// Time marshals to and unmarshals from JSON in this format.
+//
+// Timestamps are UK local time, so around the changes to and from British
+// Summer Time some are ambiguous or don't exist. A time in the hour repeated
+// when the clocks go back is taken as the first occurrence, in BST. A time in
+// the hour skipped when the clocks go forward is taken as GMT, the offset
+// before the change, so 01:30 becomes 02:30 BST. The chosen offset is shown
+// by the Zone method.
+type Time struct {
 	time.Time
 }
 
-func (t *chaosTime) UnmarshalJSON(b []byte) error {
+// Location returns the UK time zone, which the API's times are in and which
+// quotas and billing months follow. It falls back to local time if the time
+// zone database isn't available.
+func Location() *time.Location {
+	// Loading the zone reads and parses the database each time, which
+	// dominated decoding every timestamp in a response.
+	locationOnce.Do(func() {
+		// The API returns times in UK local rather than UTC
+		loc, err := time.LoadLocation("Europe/London")
+		if err != nil {
+			loc = time.Local
+		}
+		location = loc
+	})
+	return location
+}
+
+var (
+	locationOnce sync.Once
+	location     *time.Location
+)
+
+// MarshalJSON implements json.Marshaler.
+func (t Time) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte(`""`), nil
+	}
+	return []byte(`"` + t.In(Location()).Format(timeFormat) + `"`), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (t *Time) UnmarshalJSON(b []byte) error {
 	s := strings.Trim(string(b), `"`)
-	// The API returns times in UK local rather than UTC
-	loc, err := time.LoadLocation("Europe/London")
-	if err != nil {
-		loc = time.Local
+	if s == "" || s == "null" {
+		return nil
 	}
-	nt, err := time.ParseInLocation("2006-01-02 15:04:05", s, loc)
+	nt, err := parseLocal(s, Location())
 	if err != nil {
 		return err
 	}
@@ -114,63 +370,93 @@ func (t *chaosTime) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// parseLocal parses a timestamp in loc, resolving times around daylight
+// saving changes as described for Time. Unlike time.ParseInLocation, the
+// choice doesn't depend on implementation details.
+func parseLocal(s string, loc *time.Location) (time.Time, error) {
+	wall, err := time.Parse(timeFormat, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// The offsets in effect either side of the wall clock time. Changes are
+	// at least months apart, so there's at most one between them.
+	_, before := wall.Add(-12 * time.Hour).In(loc).Zone()
+	_, after := wall.Add(12 * time.Hour).In(loc).Zone()
+
+	// Each offset gives a candidate instant, which is valid if that offset
+	// is actually in effect at it. Both are valid in a repeated hour, and
+	// the earlier is first.
+	var t time.Time
+	for _, offset := range []int{before, after} {
+		c := wall.Add(-time.Duration(offset) * time.Second).In(loc)
+		if _, o := c.Zone(); o != offset {
+			continue
+		}
+		if t.IsZero() || c.Before(t) {
+			t = c
+		}
+	}
+	if t.IsZero() {
+		// The time was skipped, so use the offset from before the change.
+		t = wall.Add(-time.Duration(before) * time.Second).In(loc)
+	}
+	return t, nil
+}
+
+// QuotaReset returns when the monthly quota in effect at t is next reset,
+// which is at the start of the following month in UK time.
+func QuotaReset(t time.Time) time.Time {
+	t = t.In(Location())
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+}
+
 // BroadbandInfo represents information about a broadband line.
 type BroadbandInfo struct {
-	ID             int       `json:"id,string"`
-	Login          string    `json:"login"`
-	Postcode       string    `json:"postcode"`
-	TXRate         int       `json:"tx_rate,string"`
-	RXRate         int       `json:"rx_rate,string"`
-	TXRateAdjusted int       `json:"tx_rate_adjusted,string"`
-	QuotaMonthly   int       `json:"quota_monthly,string"`
-	QuotaRemaining int       `json:"quota_remaining,string"`
-	QuotaTimestamp chaosTime `json:"quota_timestamp"`
-}
-
-// BroadbandInfo fetches broadband info.
+	ID             int    `json:"id,string"`
+	Login          string `json:"login"`
+	Postcode       string `json:"postcode"`
+	TXRate         int    `json:"tx_rate,string"`
+	RXRate         int    `json:"rx_rate,string"`
+	TXRateAdjusted int    `json:"tx_rate_adjusted,string"`
+	QuotaMonthly   int    `json:"quota_monthly,string"`
+	QuotaRemaining int    `json:"quota_remaining,string"`
+	QuotaTimestamp Time   `json:"quota_timestamp"`
+}
+
+// BroadbandInfo fetches broadband info. If some lines can't be decoded, the
+// others are returned along with a *PartialError.
 func (api API) BroadbandInfo() ([]BroadbandInfo, error) {
-	resp, err := api.makeRequest("/broadband/info")
+	return api.BroadbandInfoContext(context.Background())
+}
+
+// BroadbandInfoContext is like BroadbandInfo, but gives up when ctx is done.
+func (api API) BroadbandInfoContext(ctx context.Context) ([]BroadbandInfo, error) {
+	r, err := call[[]json.RawMessage](ctx, api, "BroadbandInfo", BroadbandInfoEndpoint.Path, "info", nil)
 	if err != nil {
 		return nil, err
 	}
-	r := struct {
-		Info  []BroadbandInfo `json:"info"`
-		Error string          `json:"error"`
-	}{}
-	err = json.Unmarshal(resp, &r)
-	if err != nil {
-		return nil, fmt.Errorf("BroadbandInfo JSON decode: %w", err)
-	}
-	if r.Error != "" {
-		return nil, errors.New(r.Error)
-	}
-	return r.Info, nil
+	return decodeLines[broadbandInfoJSON](r, api.OnDecodeWarning)
 }
 
 // BroadbandQuota is quota.
 type BroadbandQuota struct {
-	ID             int       `json:"id,string"`
-	QuotaMonthly   int       `json:"quota_monthly"`
-	QuotaRemaining int       `json:"quota_remaining,string"`
-	QuotaTimestamp chaosTime `json:"quota_timestamp,string"`
+	ID             int  `json:"id,string"`
+	QuotaMonthly   int  `json:"quota_monthly"`
+	QuotaRemaining int  `json:"quota_remaining,string"`
+	QuotaTimestamp Time `json:"quota_timestamp,string"`
 }
 
-// BroadbandQuota fetches the broadband quota.
+// BroadbandQuota fetches the broadband quota. If some lines can't be decoded,
+// the others are returned along with a *PartialError.
 func (api API) BroadbandQuota() ([]BroadbandQuota, error) {
-	resp, err := api.makeRequest("/broadband/quota")
+	return api.BroadbandQuotaContext(context.Background())
+}
+
+// BroadbandQuotaContext is like BroadbandQuota, but gives up when ctx is done.
+func (api API) BroadbandQuotaContext(ctx context.Context) ([]BroadbandQuota, error) {
+	r, err := call[[]json.RawMessage](ctx, api, "BroadbandQuota", BroadbandQuotaEndpoint.Path, "quota", nil)
 	if err != nil {
 		return nil, err
 	}
-	r := struct {
-		Quota []BroadbandQuota `json:"quota"`
-		Error string           `json:"error"`
-	}{}
-	err = json.Unmarshal(resp, &r)
-	if err != nil {
-		return nil, fmt.Errorf("BroadbandQuota JSON decode: %w", err)
-	}
-	if r.Error != "" {
-		return nil, errors.New(r.Error)
-	}
-	return r.Quota, nil
+	return decodeLines[broadbandQuotaJSON](r, api.OnDecodeWarning)
 }
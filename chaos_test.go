@@ -0,0 +1,129 @@
+package chaos
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// infoResponse returns a /broadband/info response with n lines.
+func infoResponse(n int) string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf(`{"id":"%d","login":"line%d@a.1","postcode":"AB1 2CD",`+
+			`"tx_rate":"80000000","rx_rate":"20000000","tx_rate_adjusted":"76000000",`+
+			`"quota_monthly":"1000000000000","quota_remaining":"400000000000",`+
+			`"quota_timestamp":"2024-06-14 12:00:00"}`, 12345+i, i)
+	}
+	return `{"info":[` + strings.Join(lines, ",") + `]}`
+}
+
+func BenchmarkBroadbandInfo(b *testing.B) {
+	body := infoResponse(10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	api := New(Auth{ControlLogin: "login", ControlPassword: "password"})
+	api.Endpoint = srv.URL
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		info, err := api.BroadbandInfo()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(info) != 10 {
+			b.Fatalf("got %d lines, want 10", len(info))
+		}
+	}
+}
+
+func TestParseLocal(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"winter", "2025-01-15 12:00:00", "2025-01-15T12:00:00Z"},
+		{"summer", "2025-07-15 12:00:00", "2025-07-15T11:00:00Z"},
+		// The clocks go forward from 01:00 GMT to 02:00 BST, so 01:30 doesn't
+		// exist and is taken as GMT.
+		{"spring forward gap", "2025-03-30 01:30:00", "2025-03-30T01:30:00Z"},
+		{"after spring forward", "2025-03-30 02:30:00", "2025-03-30T01:30:00Z"},
+		// The clocks go back from 02:00 BST to 01:00 GMT, so 01:30 happens
+		// twice and the earlier, BST, instant is chosen.
+		{"autumn repeated hour", "2025-10-26 01:30:00", "2025-10-26T00:30:00Z"},
+		{"after autumn repeat", "2025-10-26 02:30:00", "2025-10-26T02:30:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLocal(tt.in, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := got.UTC().Format(time.RFC3339); s != tt.want {
+				t.Errorf("parseLocal(%q) = %s, want %s", tt.in, s, tt.want)
+			}
+		})
+	}
+}
+
+// quotaResponse returns a /broadband/quota response with n lines.
+func quotaResponse(n int) string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf(`{"id":"%d","quota_monthly":"1000000000000",`+
+			`"quota_remaining":"400000000000","quota_timestamp":"2024-06-14 12:00:00"}`, 12345+i)
+	}
+	return `{"quota":[` + strings.Join(lines, ",") + `]}`
+}
+
+// benchmarkDecode decodes body's key field and its lines as the typed calls
+// do, without the HTTP round trip, so only decoding is measured.
+func benchmarkDecode[R interface{ value(warnFunc) T }, T any](b *testing.B, body []byte, key string, want int) {
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var raw []json.RawMessage
+		if err := decodeField(body, key, &raw); err != nil {
+			b.Fatal(err)
+		}
+		lines, err := decodeLines[R](raw, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(lines) != want {
+			b.Fatalf("got %d lines, want %d", len(lines), want)
+		}
+	}
+}
+
+func BenchmarkDecodeBroadbandInfo(b *testing.B) {
+	for _, n := range []int{1, 10, 1000} {
+		body := []byte(infoResponse(n))
+		b.Run(fmt.Sprintf("lines=%d", n), func(b *testing.B) {
+			benchmarkDecode[broadbandInfoJSON](b, body, "info", n)
+		})
+	}
+}
+
+func BenchmarkDecodeBroadbandQuota(b *testing.B) {
+	for _, n := range []int{1, 10, 1000} {
+		body := []byte(quotaResponse(n))
+		b.Run(fmt.Sprintf("lines=%d", n), func(b *testing.B) {
+			benchmarkDecode[broadbandQuotaJSON](b, body, "quota", n)
+		})
+	}
+}
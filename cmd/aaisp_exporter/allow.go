@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parseAllowList parses CIDR prefixes, or single addresses, which may also be
+// given comma-separated.
+func parseAllowList(list []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, l := range list {
+		for _, s := range strings.Split(l, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			if !strings.Contains(s, "/") {
+				addr, err := netip.ParseAddr(s)
+				if err != nil {
+					return nil, fmt.Errorf("invalid address or CIDR %q", s)
+				}
+				prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+				continue
+			}
+			p, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address or CIDR %q", s)
+			}
+			prefixes = append(prefixes, p.Masked())
+		}
+	}
+	return prefixes, nil
+}
+
+// allowCIDR returns middleware which rejects requests from addresses outside
+// prefixes. Addresses are checked as the exporter sees them, so a reverse
+// proxy's address must be allowed rather than its clients'.
+func allowCIDR(prefixes []netip.Prefix) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			addr, err := netip.ParseAddr(host)
+			if err == nil {
+				// IPv4 clients of a dual-stack listener appear as
+				// IPv4-mapped IPv6 addresses.
+				addr = addr.Unmap().WithZone("")
+				for _, p := range prefixes {
+					if p.Contains(addr) {
+						next.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
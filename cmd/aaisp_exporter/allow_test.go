@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowCIDR(t *testing.T) {
+	allowed, err := parseAllowList([]string{"192.0.2.0/24, 2001:db8::/32", "198.51.100.7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := allowCIDR(allowed)(okHandler)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       int
+	}{
+		{"IPv4", "192.0.2.10:51234", "", http.StatusOK},
+		{"single IPv4 address", "198.51.100.7:51234", "", http.StatusOK},
+		{"IPv6", "[2001:db8::1]:51234", "", http.StatusOK},
+		{"IPv6 with zone", "[2001:db8::1%eth0]:51234", "", http.StatusOK},
+		{"IPv4-mapped IPv6", "[::ffff:192.0.2.10]:51234", "", http.StatusOK},
+		{"no port", "192.0.2.10", "", http.StatusOK},
+		{"denied IPv4", "203.0.113.1:51234", "", http.StatusForbidden},
+		{"denied IPv6", "[2001:db9::1]:51234", "", http.StatusForbidden},
+		{"denied IPv4-mapped IPv6", "[::ffff:203.0.113.1]:51234", "", http.StatusForbidden},
+		{"unparseable", "@", "", http.StatusForbidden},
+		// X-Forwarded-For isn't trusted, so it can't be used to get in.
+		{"forwarded for allowed address", "203.0.113.1:51234", "192.0.2.10", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("RemoteAddr %s: got status %d, want %d", tt.remoteAddr, w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAllowListInvalid(t *testing.T) {
+	for _, s := range []string{"192.0.2.0/33", "not-an-address", "2001:db8::/129"} {
+		if _, err := parseAllowList([]string{s}); err == nil {
+			t.Errorf("parseAllowList(%q) succeeded, want an error", s)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+	"github.com/rs/zerolog"
+)
+
+// apiHandler serves a read-only JSON API of line data from src, so several
+// clients can share one set of credentials and one API call budget. Use a
+// cache or background polling to get the benefit. Responses use the same JSON
+// encoding as the CHAOS API.
+//
+//	GET /lines             all lines
+//	GET /lines/{id}        a single line
+//	GET /lines/{id}/quota  a line's quota
+type apiHandler struct {
+	src lineSource
+}
+
+func (h apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "lines" || len(parts) > 3 || len(parts) == 3 && parts[2] != "quota" {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	lines, err := h.src.BroadbandInfo()
+	if err != nil {
+		zerolog.Ctx(r.Context()).Error().Err(err).Msg("error getting broadband info for API")
+		writeJSONError(w, http.StatusBadGateway, "error getting line information")
+		return
+	}
+	if len(parts) == 1 {
+		writeJSON(w, lines)
+		return
+	}
+
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	for _, l := range lines {
+		if l.ID != id {
+			continue
+		}
+		if len(parts) == 3 {
+			writeJSON(w, chaos.BroadbandQuota{
+				ID:             l.ID,
+				QuotaMonthly:   l.QuotaMonthly,
+				QuotaRemaining: l.QuotaRemaining,
+				QuotaTimestamp: l.QuotaTimestamp,
+			})
+			return
+		}
+		writeJSON(w, l)
+		return
+	}
+	writeJSONError(w, http.StatusNotFound, "line not found")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{msg})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// bearerAuth returns middleware which rejects requests that don't present
+// token in the Authorization header.
+func bearerAuth(token string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			// The scheme is case-insensitive (RFC 7235).
+			scheme, got, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// restrict returns middleware which requires token, if it's set, and a client
+// address in allowed, if it isn't empty.
+func restrict(token string, allowed []netip.Prefix) func(next http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if token != "" {
+			h = bearerAuth(token)(h)
+		}
+		if len(allowed) > 0 {
+			h = allowCIDR(allowed)(h)
+		}
+		return h
+	}
+}
+
+// readToken reads a bearer token from a file, ignoring surrounding whitespace.
+// An empty file is an error, rather than leaving the endpoints unprotected.
+func readToken(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return token, nil
+}
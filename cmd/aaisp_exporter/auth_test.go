@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func TestBearerAuth(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", restrict("s3cret", nil)(okHandler))
+	mux.HandleFunc("/healthz", healthz)
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		want   int
+	}{
+		{"missing header", "/metrics", "", http.StatusUnauthorized},
+		{"wrong scheme", "/metrics", "Basic s3cret", http.StatusUnauthorized},
+		{"no token", "/metrics", "Bearer", http.StatusUnauthorized},
+		{"wrong token", "/metrics", "Bearer wrong", http.StatusUnauthorized},
+		{"right token", "/metrics", "Bearer s3cret", http.StatusOK},
+		{"lower case scheme", "/metrics", "bearer s3cret", http.StatusOK},
+		{"upper case scheme", "/metrics", "BEARER s3cret", http.StatusOK},
+		{"exempt path", "/healthz", "", http.StatusOK},
+		{"exempt path with wrong token", "/healthz", "Bearer wrong", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d", w.Code, tt.want)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("WWW-Authenticate = %q, want Bearer", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lineSource is anything which can provide broadband line information.
+type lineSource interface {
+	BroadbandInfo() ([]chaos.BroadbandInfo, error)
+}
+
+// contextLineSource is a lineSource which can give up when a context is done.
+type contextLineSource interface {
+	BroadbandInfoContext(ctx context.Context) ([]chaos.BroadbandInfo, error)
+}
+
+// broadbandInfo gets line information from src, giving up when ctx is done if
+// src supports it.
+func broadbandInfo(ctx context.Context, src lineSource) ([]chaos.BroadbandInfo, error) {
+	if src, ok := src.(contextLineSource); ok {
+		return src.BroadbandInfoContext(ctx)
+	}
+	return src.BroadbandInfo()
+}
+
+// quotaLines is a lineSource which uses the smaller BroadbandQuota response
+// instead of BroadbandInfo, for when only quota metrics are wanted. Only the
+// lines' IDs and quota fields are set.
+type quotaLines struct {
+	api *chaos.API
+}
+
+func (q quotaLines) BroadbandInfo() ([]chaos.BroadbandInfo, error) {
+	return q.BroadbandInfoContext(context.Background())
+}
+
+func (q quotaLines) BroadbandInfoContext(ctx context.Context) ([]chaos.BroadbandInfo, error) {
+	quotas, err := q.api.BroadbandQuotaContext(ctx)
+	lines := make([]chaos.BroadbandInfo, len(quotas))
+	for i, q := range quotas {
+		lines[i] = chaos.BroadbandInfo{
+			ID:             q.ID,
+			QuotaMonthly:   q.QuotaMonthly,
+			QuotaRemaining: q.QuotaRemaining,
+			QuotaTimestamp: q.QuotaTimestamp,
+		}
+	}
+	return lines, err
+}
+
+const cacheAgeName = "aaisp_exporter_cache_age_seconds"
+
+var (
+	cacheHits = metric{
+		name: "aaisp_exporter_cache_hits_total",
+		help: "Number of scrapes served from cached API responses",
+	}
+	cacheMisses = metric{
+		name: "aaisp_exporter_cache_misses_total",
+		help: "Number of scrapes which required a call to the AAISP API",
+	}
+
+	cacheHitsCounter   = prometheus.NewCounter(prometheus.CounterOpts{Name: cacheHits.name, Help: cacheHits.help})
+	cacheMissesCounter = prometheus.NewCounter(prometheus.CounterOpts{Name: cacheMisses.name, Help: cacheMisses.help})
+)
+
+// infoCache caches broadband line information for ttl, to limit how often the
+// CHAOS API is called regardless of how often the exporter is scraped.
+//
+// Errors are never cached, and nor are partial results.
+type infoCache struct {
+	lineSource
+	ttl   time.Duration
+	clock clock
+
+	mu      sync.Mutex
+	lines   []chaos.BroadbandInfo
+	updated time.Time
+}
+
+func (c *infoCache) BroadbandInfo() ([]chaos.BroadbandInfo, error) {
+	return c.BroadbandInfoContext(context.Background())
+}
+
+func (c *infoCache) BroadbandInfoContext(ctx context.Context) ([]chaos.BroadbandInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.updated.IsZero() && c.clock.Now().Sub(c.updated) < c.ttl {
+		cacheHitsCounter.Inc()
+		return c.lines, nil
+	}
+	cacheMissesCounter.Inc()
+	lines, err := broadbandInfo(ctx, c.lineSource)
+	if err != nil {
+		return lines, err
+	}
+	c.lines = lines
+	c.updated = c.clock.Now()
+	return lines, nil
+}
+
+// age returns the number of seconds since the cache was last refreshed.
+func (c *infoCache) age() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.updated.IsZero() {
+		return 0
+	}
+	return c.clock.Now().Sub(c.updated).Seconds()
+}
+
+// register registers the cache's self-metrics.
+func (c *infoCache) register(reg prometheus.Registerer) {
+	reg.MustRegister(cacheHitsCounter, cacheMissesCounter)
+	reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: cacheAgeName,
+		Help: "Seconds since the cached API response was last refreshed",
+	}, c.age))
+}
@@ -0,0 +1,22 @@
+package main
+
+import "time"
+
+// clock tells the time. Time-dependent logic such as caching, quota
+// predictions and top-up detection uses a clock rather than calling time.Now
+// so it can run against a simulated time.
+type clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// offsetClock runs at normal speed from a different starting time, e.g. to
+// see how notifications behave across the monthly quota reset.
+type offsetClock struct {
+	offset time.Duration
+}
+
+func (c offsetClock) Now() time.Time { return time.Now().Add(c.offset) }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jamesog/aaisp-chaos/internal/sigv4"
+)
+
+// cloudwatchBatch is the most metric values PutMetricData accepts per request.
+const cloudwatchBatch = 1000
+
+// cloudwatchConfig configures the CloudWatch sink.
+type cloudwatchConfig struct {
+	region     string
+	namespace  string
+	endpoint   string
+	dimensions stringList
+}
+
+func addCloudWatchFlags(fs *flag.FlagSet) *cloudwatchConfig {
+	c := new(cloudwatchConfig)
+	fs.StringVar(&c.region, "cloudwatch.region", "", "publish metrics to CloudWatch in AWS `region`")
+	fs.StringVar(&c.namespace, "cloudwatch.namespace", "AAISP", "CloudWatch `namespace`")
+	fs.StringVar(&c.endpoint, "cloudwatch.endpoint", "", "CloudWatch endpoint `URL`, e.g. for a VPC endpoint (defaults to the region's public endpoint)")
+	fs.Var(&c.dimensions, "cloudwatch.dimension", "add `name=value` as a dimension to all metrics; may be repeated")
+	return c
+}
+
+// sink returns the configured sink, or nil if CloudWatch isn't configured.
+// Credentials are read from the standard AWS environment variables, or if
+// they aren't set, fetched for the ECS task's or EC2 instance's role.
+func (c *cloudwatchConfig) sink() (sink, error) {
+	if c.region == "" {
+		return nil, nil
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	s := &cloudwatchSink{
+		config: *c,
+		keys: &sigv4.Source{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+			Client:          client,
+		},
+		client: client,
+	}
+	if (s.keys.AccessKeyID == "") != (s.keys.SecretAccessKey == "") {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must both be set in the environment, or neither to use the instance's role")
+	}
+	if s.config.endpoint == "" {
+		s.config.endpoint = "https://monitoring." + c.region + ".amazonaws.com/"
+	}
+	for _, d := range c.dimensions {
+		name, value, ok := strings.Cut(d, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid -cloudwatch.dimension %q: must be name=value", d)
+		}
+		s.dimensions = append(s.dimensions, label{name, value})
+	}
+	return s, nil
+}
+
+// cloudwatchSink publishes metrics using PutMetricData. The metric name has the
+// "aaisp_" prefix removed, and labels become dimensions.
+type cloudwatchSink struct {
+	config     cloudwatchConfig
+	dimensions []label
+	keys       *sigv4.Source
+	client     *http.Client
+}
+
+func (s *cloudwatchSink) name() string { return "cloudwatch" }
+
+// cloudwatchUnit returns the CloudWatch unit for a metric.
+func cloudwatchUnit(name string) string {
+	switch {
+	case strings.HasSuffix(name, "_rate"):
+		return "Bits/Second"
+	case strings.HasSuffix(name, "_timestamp_seconds"):
+		// A point in time, not a duration.
+		return "None"
+	case strings.HasSuffix(name, "_seconds"):
+		return "Seconds"
+	case strings.Contains(name, "_quota_"):
+		return "Bytes"
+	}
+	return "None"
+}
+
+func (s *cloudwatchSink) push(samples []sample) error {
+	for len(samples) > 0 {
+		n := len(samples)
+		if n > cloudwatchBatch {
+			n = cloudwatchBatch
+		}
+		if err := s.put(samples[:n]); err != nil {
+			return err
+		}
+		samples = samples[n:]
+	}
+	return nil
+}
+
+func (s *cloudwatchSink) put(samples []sample) error {
+	form := url.Values{
+		"Action":    {"PutMetricData"},
+		"Version":   {"2010-08-01"},
+		"Namespace": {s.config.namespace},
+	}
+	for i, sm := range samples {
+		member := "MetricData.member." + strconv.Itoa(i+1) + "."
+		form.Set(member+"MetricName", strings.TrimPrefix(sm.name, "aaisp_"))
+		form.Set(member+"Value", strconv.FormatFloat(sm.value, 'f', -1, 64))
+		form.Set(member+"Unit", cloudwatchUnit(sm.name))
+		form.Set(member+"Timestamp", sm.time.UTC().Format(time.RFC3339))
+		dims := append(append([]label(nil), s.dimensions...), sm.labels...)
+		for j, d := range dims {
+			dim := member + "Dimensions.member." + strconv.Itoa(j+1) + "."
+			form.Set(dim+"Name", d.name)
+			form.Set(dim+"Value", d.value)
+		}
+	}
+
+	keys, err := s.keys.Keys(context.Background())
+	if err != nil {
+		return fmt.Errorf("getting AWS credentials: %w", err)
+	}
+	body := form.Encode()
+	req, err := http.NewRequest("POST", s.config.endpoint, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	sigv4.Sign(req, []byte(body), keys, s.config.region, "monitoring", time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("bad response code: %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// dashboardPanel describes a Grafana panel graphing one of the exporter's
+// metrics. The metric name and labels are taken from the same definition as
+// its Desc, so the dashboard can't drift from what's actually exposed.
+type dashboardPanel struct {
+	metric metric
+	title  string
+	kind   string // Grafana panel type
+	unit   string // Grafana unit ID
+	// expr wraps the selector, e.g. "rate(%s[5m])". Empty uses it as is.
+	expr string
+}
+
+var (
+	broadbandPanels = []dashboardPanel{
+		{metric: scrapeSuccess, title: "API scrape success", kind: "stat", unit: "bool_yes_no"},
+		{metric: broadbandQuotaRemaining, title: "Quota remaining", kind: "timeseries", unit: "decbytes"},
+		{metric: broadbandQuotaTotal, title: "Monthly quota", kind: "timeseries", unit: "decbytes"},
+		{metric: broadbandQuotaReset, title: "Days until quota reset", kind: "stat", unit: "d", expr: "(%s - time()) / 86400"},
+		{metric: broadbandTXRate, title: "Sync rate (transmit)", kind: "timeseries", unit: "bps"},
+		{metric: broadbandRXRate, title: "Sync rate (receive)", kind: "timeseries", unit: "bps"},
+		{metric: broadbandLineInfo, title: "Lines", kind: "table"},
+	}
+	// quotaPanels are the broadband panels with data in quota-only mode.
+	quotaPanels = []dashboardPanel{
+		broadbandPanels[0], broadbandPanels[1], broadbandPanels[2], broadbandPanels[3],
+	}
+	cachePanels = []dashboardPanel{
+		{metric: cacheHits, title: "Cache hits", kind: "timeseries", unit: "reqps", expr: "rate(%s[5m])"},
+		{metric: cacheMisses, title: "Cache misses", kind: "timeseries", unit: "reqps", expr: "rate(%s[5m])"},
+	}
+)
+
+// generateDashboard writes a Grafana dashboard for the metrics the exporter
+// would expose with the given filters.
+func generateDashboard(w io.Writer, g filterGatherer, panels []dashboardPanel) error {
+	type gridPos struct {
+		H int `json:"h"`
+		W int `json:"w"`
+		X int `json:"x"`
+		Y int `json:"y"`
+	}
+	type target struct {
+		Expr         string `json:"expr"`
+		LegendFormat string `json:"legendFormat"`
+		RefID        string `json:"refId"`
+		Format       string `json:"format,omitempty"`
+		Instant      bool   `json:"instant,omitempty"`
+	}
+	type panel struct {
+		ID          int                    `json:"id"`
+		Title       string                 `json:"title"`
+		Description string                 `json:"description,omitempty"`
+		Type        string                 `json:"type"`
+		Datasource  map[string]string      `json:"datasource"`
+		GridPos     gridPos                `json:"gridPos"`
+		FieldConfig map[string]interface{} `json:"fieldConfig"`
+		Targets     []target               `json:"targets"`
+	}
+
+	datasource := map[string]string{"type": "prometheus", "uid": "${datasource}"}
+	var (
+		out        []panel
+		lineMetric string // a metric to discover line_id values from
+		x, y       int
+	)
+	for _, p := range panels {
+		name, help, labels := p.metric.name, p.metric.help, p.metric.labels
+		if !g.exposes(name) {
+			continue
+		}
+		selector, legend := name, name
+		for _, l := range labels {
+			if l == "line_id" && !g.dropLabels[l] {
+				if lineMetric == "" {
+					lineMetric = name
+				}
+				selector = name + `{line_id=~"$line_id"}`
+				legend = "{{line_id}}"
+			}
+		}
+		expr := selector
+		if p.expr != "" {
+			expr = fmt.Sprintf(p.expr, selector)
+		}
+		t := target{Expr: expr, LegendFormat: legend, RefID: "A"}
+		width := 12
+		switch p.kind {
+		case "stat":
+			width = 24
+		case "table":
+			// Show the labels of each line, rather than a graph of the
+			// constant value.
+			width = 24
+			t.Format, t.Instant = "table", true
+		}
+		if x+width > 24 {
+			x, y = 0, y+8
+		}
+		out = append(out, panel{
+			ID:          len(out) + 1,
+			Title:       p.title,
+			Description: help,
+			Type:        p.kind,
+			Datasource:  datasource,
+			GridPos:     gridPos{H: 8, W: width, X: x, Y: y},
+			FieldConfig: map[string]interface{}{
+				"defaults":  map[string]string{"unit": p.unit},
+				"overrides": []interface{}{},
+			},
+			Targets: []target{t},
+		})
+		x += width
+	}
+	if len(out) == 0 {
+		return fmt.Errorf("no metrics are exposed with the current filters")
+	}
+
+	templates := []interface{}{
+		map[string]interface{}{
+			"name":  "datasource",
+			"label": "Data source",
+			"type":  "datasource",
+			"query": "prometheus",
+		},
+	}
+	if lineMetric != "" {
+		templates = append(templates, map[string]interface{}{
+			"name":       "line_id",
+			"label":      "Line",
+			"type":       "query",
+			"datasource": datasource,
+			"query":      fmt.Sprintf("label_values(%s, line_id)", lineMetric),
+			"refresh":    2,
+			"multi":      true,
+			"includeAll": true,
+			"current":    map[string]interface{}{"text": "All", "value": "$__all"},
+		})
+	}
+
+	dashboard := map[string]interface{}{
+		"title":         "AAISP",
+		"uid":           "aaisp-chaos",
+		"tags":          []string{"aaisp"},
+		"schemaVersion": 39,
+		"editable":      true,
+		"refresh":       "5m",
+		"time":          map[string]string{"from": "now-7d", "to": "now"},
+		"templating":    map[string]interface{}{"list": templates},
+		"panels":        out,
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dashboard)
+}
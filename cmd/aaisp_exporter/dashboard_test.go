@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// staticLines is a lineSource which always returns the same lines.
+type staticLines []chaos.BroadbandInfo
+
+func (s staticLines) BroadbandInfo() ([]chaos.BroadbandInfo, error) { return s, nil }
+
+// exposedNames returns the names of every metric the collector and cache
+// expose.
+func exposedNames(t *testing.T) []string {
+	t.Helper()
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(broadbandCollector{lineSource: staticLines{{ID: 1, Login: "line@a.1"}}})
+	reg.MustRegister(cacheHitsCounter, cacheMissesCounter)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, mf := range mfs {
+		names = append(names, mf.GetName())
+	}
+	return names
+}
+
+func TestDashboardCoversMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	panels := append(append([]dashboardPanel(nil), broadbandPanels...), cachePanels...)
+	if err := generateDashboard(&buf, filterGatherer{}, panels); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range exposedNames(t) {
+		if !strings.Contains(buf.String(), name) {
+			t.Errorf("dashboard has no panel for %s", name)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// datadogGauge is the metric type for gauges in the v2 series API.
+const datadogGauge = 3
+
+// datadogConfig configures the Datadog sink.
+type datadogConfig struct {
+	apiKey string
+	site   string
+	prefix string
+	host   string
+	tags   stringList
+}
+
+func addDatadogFlags(fs *flag.FlagSet) *datadogConfig {
+	c := new(datadogConfig)
+	fs.StringVar(&c.apiKey, "datadog.api-key", "", "submit metrics to Datadog using API `key`")
+	fs.StringVar(&c.site, "datadog.site", "datadoghq.com", "Datadog `site`, e.g. datadoghq.eu")
+	fs.StringVar(&c.prefix, "datadog.prefix", "aaisp", "`prefix` for Datadog metric names")
+	fs.StringVar(&c.host, "datadog.host", "", "report metrics against Datadog `host`")
+	fs.Var(&c.tags, "datadog.tag", "add `tag` (e.g. account:home) to all metrics; may be repeated")
+	return c
+}
+
+// sink returns the configured sink, or nil if Datadog isn't configured.
+func (c *datadogConfig) sink() (sink, error) {
+	if c.apiKey == "" {
+		return nil, nil
+	}
+	return &datadogSink{config: *c, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// datadogSink submits metrics as gauges to the Datadog series API. Names are
+// built as for StatsD, e.g. "aaisp.broadband_quota_remaining", and labels are
+// sent as tags.
+type datadogSink struct {
+	config datadogConfig
+	client *http.Client
+}
+
+type datadogSeries struct {
+	Metric    string            `json:"metric"`
+	Type      int               `json:"type"`
+	Points    []datadogPoint    `json:"points"`
+	Tags      []string          `json:"tags,omitempty"`
+	Resources []datadogResource `json:"resources,omitempty"`
+}
+
+type datadogPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+type datadogResource struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+func (s *datadogSink) name() string { return "datadog" }
+
+func (s *datadogSink) series(sm sample) datadogSeries {
+	name := graphiteSanitize(strings.TrimPrefix(sm.name, "aaisp_"))
+	if s.config.prefix != "" {
+		name = s.config.prefix + "." + name
+	}
+	tags := append([]string(nil), s.config.tags...)
+	for _, l := range sm.labels {
+		tags = append(tags, l.name+":"+l.value)
+	}
+	series := datadogSeries{
+		Metric: name,
+		Type:   datadogGauge,
+		Points: []datadogPoint{{Timestamp: sm.time.Unix(), Value: sm.value}},
+		Tags:   tags,
+	}
+	if s.config.host != "" {
+		series.Resources = []datadogResource{{Name: s.config.host, Type: "host"}}
+	}
+	return series
+}
+
+func (s *datadogSink) push(samples []sample) error {
+	body := struct {
+		Series []datadogSeries `json:"series"`
+	}{}
+	for _, sm := range samples {
+		body.Series = append(body.Series, s.series(sm))
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", "https://api."+s.config.site+"/api/v2/series", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", s.config.apiKey)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("bad response code: %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Discord embed colours.
+const (
+	discordColorWarning = 0xf0a030
+	discordColorOK      = 0x2ecc71
+)
+
+// discordNotifier sends events to a Discord webhook as embeds.
+type discordNotifier struct {
+	url    string
+	events map[string]bool // nil means all events
+	client *http.Client
+}
+
+func newDiscordNotifier(url, events string) *discordNotifier {
+	n := &discordNotifier{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+	if events != "" {
+		n.events = make(map[string]bool)
+		for _, e := range strings.Split(events, ",") {
+			n.events[strings.TrimSpace(e)] = true
+		}
+	}
+	return n
+}
+
+func (n *discordNotifier) name() string { return "discord" }
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Color       int            `json:"color"`
+	Fields      []discordField `json:"fields"`
+	Timestamp   string         `json:"timestamp"`
+}
+
+func (n *discordNotifier) notify(e event) error {
+	if n.events != nil && !n.events[e.Type] {
+		return nil
+	}
+	color := discordColorWarning
+	if e.Status == statusResolved || e.Type == eventTopUp {
+		color = discordColorOK
+	}
+	embed := discordEmbed{
+		Title:       e.title(),
+		Description: e.Message,
+		Color:       color,
+		Fields: []discordField{
+			{Name: "Line", Value: e.LineID, Inline: true},
+			{Name: "Quota remaining", Value: formatBytes(e.QuotaRemaining) + " of " + formatBytes(e.QuotaMonthly), Inline: true},
+		},
+		Timestamp: e.Time.Format(time.RFC3339),
+	}
+	if e.PredictedExhaustion != nil {
+		embed.Fields = append(embed.Fields, discordField{
+			Name:   "Predicted exhaustion",
+			Value:  e.PredictedExhaustion.Format("Mon 2 Jan 15:04"),
+			Inline: true,
+		})
+	}
+	msg := struct {
+		Embeds []discordEmbed `json:"embeds"`
+	}{[]discordEmbed{embed}}
+	return postJSON(n.client, n.url, msg)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// feedSize is how many recent events the Atom feed lists.
+const feedSize = 50
+
+// feedNotifier keeps recent events in memory and serves them as an Atom feed,
+// for feed readers and services like IFTTT. Events are lost on restart.
+type feedNotifier struct {
+	mu     sync.Mutex
+	events []event // oldest first
+}
+
+func (f *feedNotifier) name() string { return "feed" }
+
+func (f *feedNotifier) notify(e event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, e)
+	if len(f.events) > feedSize {
+		f.events = f.events[len(f.events)-feedSize:]
+	}
+	return nil
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title   string `xml:"title"`
+	ID      string `xml:"id"`
+	Updated string `xml:"updated"`
+	Summary string `xml:"summary"`
+	// Category is the event type, so feeds can be filtered.
+	Category struct {
+		Term string `xml:"term,attr"`
+	} `xml:"category"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Author  string      `xml:"author>name"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+func (f *feedNotifier) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	events := append([]event(nil), f.events...)
+	f.mu.Unlock()
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	feed := atomFeed{
+		Title:  "AAISP line events",
+		ID:     "urn:aaisp-chaos:events",
+		Link:   atomLink{Rel: "self", Href: scheme + "://" + r.Host + r.URL.Path},
+		Author: "aaisp_exporter",
+		// A feed with no entries still needs an updated time.
+		Updated: time.Now().UTC().Format(time.RFC3339),
+	}
+	// Newest first.
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		entry := atomEntry{
+			Title:   fmt.Sprintf("%s: line %s", e.title(), e.LineID),
+			ID:      fmt.Sprintf("urn:aaisp-chaos:event:%s:%s:%s:%d", e.LineID, e.Type, e.Status, e.Time.UnixNano()),
+			Updated: e.Time.UTC().Format(time.RFC3339),
+			Summary: e.Message,
+		}
+		if e.PredictedExhaustion != nil {
+			entry.Summary += ". Predicted to run out " + e.PredictedExhaustion.Format("Mon 2 Jan 15:04")
+		}
+		entry.Category.Term = e.Type
+		feed.Entries = append(feed.Entries, entry)
+	}
+	if len(events) > 0 {
+		feed.Updated = events[len(events)-1].Time.UTC().Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(feed)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// filterGatherer wraps a Gatherer, dropping metric families and labels before
+// they're exposed. It's a lightweight alternative to relabelling in Prometheus.
+type filterGatherer struct {
+	prometheus.Gatherer
+	keep       *regexp.Regexp
+	drop       *regexp.Regexp
+	dropLabels map[string]bool
+}
+
+func (g filterGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+	return g.filter(mfs), err
+}
+
+// filter drops metric families and labels from mfs, modifying it in place.
+func (g filterGatherer) filter(mfs []*dto.MetricFamily) []*dto.MetricFamily {
+	filtered := mfs[:0]
+	for _, mf := range mfs {
+		if !g.exposes(mf.GetName()) {
+			continue
+		}
+		if len(g.dropLabels) > 0 {
+			for _, m := range mf.Metric {
+				labels := m.Label[:0]
+				for _, l := range m.Label {
+					if !g.dropLabels[l.GetName()] {
+						labels = append(labels, l)
+					}
+				}
+				m.Label = labels
+			}
+		}
+		filtered = append(filtered, mf)
+	}
+	return filtered
+}
+
+// exposes reports whether the metric name survives the keep and drop filters.
+func (g filterGatherer) exposes(name string) bool {
+	if g.keep != nil && !g.keep.MatchString(name) {
+		return false
+	}
+	if g.drop != nil && g.drop.MatchString(name) {
+		return false
+	}
+	return true
+}
+
+// compileAnchored compiles a regular expression which must match the whole
+// metric name, in the same way as Prometheus relabelling.
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// graphiteConfig configures the Graphite sink.
+type graphiteConfig struct {
+	address  string
+	prefix   string
+	protocol string
+}
+
+func addGraphiteFlags(fs *flag.FlagSet) *graphiteConfig {
+	c := new(graphiteConfig)
+	fs.StringVar(&c.address, "graphite.address", "", "push metrics to the Graphite carbon receiver at `host:port`")
+	fs.StringVar(&c.prefix, "graphite.prefix", "aaisp", "`prefix` for Graphite metric paths")
+	fs.StringVar(&c.protocol, "graphite.protocol", "plaintext", "Graphite `protocol` (plaintext, pickle)")
+	return c
+}
+
+// sink returns the configured sink, or nil if Graphite isn't configured.
+func (c *graphiteConfig) sink() (sink, error) {
+	if c.address == "" {
+		return nil, nil
+	}
+	if c.protocol != "plaintext" && c.protocol != "pickle" {
+		return nil, fmt.Errorf("unknown Graphite protocol %q", c.protocol)
+	}
+	return &graphiteSink{config: *c}, nil
+}
+
+// graphiteSink sends metrics to Graphite. Metric paths are built from the
+// prefix, the metric name without its "aaisp_" prefix, and the label values,
+// e.g. "aaisp.broadband_quota_remaining.12345".
+type graphiteSink struct {
+	config graphiteConfig
+}
+
+func (s *graphiteSink) name() string { return "graphite" }
+
+func (s *graphiteSink) path(sm sample) string {
+	parts := []string{graphiteSanitize(strings.TrimPrefix(sm.name, "aaisp_"))}
+	if s.config.prefix != "" {
+		parts = append([]string{s.config.prefix}, parts...)
+	}
+	for _, l := range sm.labels {
+		parts = append(parts, graphiteSanitize(l.value))
+	}
+	return strings.Join(parts, ".")
+}
+
+func (s *graphiteSink) push(samples []sample) error {
+	var buf bytes.Buffer
+	if s.config.protocol == "pickle" {
+		s.pickle(&buf, samples)
+	} else {
+		s.plaintext(&buf, samples)
+	}
+
+	conn, err := net.DialTimeout("tcp", s.config.address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err = buf.WriteTo(conn)
+	return err
+}
+
+// plaintext writes samples in carbon's plaintext format, a line of
+// "path value timestamp" for each.
+func (s *graphiteSink) plaintext(buf *bytes.Buffer, samples []sample) {
+	for _, sm := range samples {
+		fmt.Fprintf(buf, "%s %s %d\n", s.path(sm), strconv.FormatFloat(sm.value, 'f', -1, 64), sm.time.Unix())
+	}
+}
+
+// pickle writes samples in carbon's pickle format: a length-prefixed pickled
+// list of (path, (timestamp, value)) tuples. Timestamps are floats, as
+// BININT is only 32 bits and would overflow in 2038.
+func (s *graphiteSink) pickle(buf *bytes.Buffer, samples []sample) {
+	var p bytes.Buffer
+	p.WriteString("\x80\x02](") // PROTO 2, EMPTY_LIST, MARK
+	for _, sm := range samples {
+		path := s.path(sm)
+		p.WriteByte('X') // BINUNICODE
+		binary.Write(&p, binary.LittleEndian, uint32(len(path)))
+		p.WriteString(path)
+		p.WriteByte('G') // BINFLOAT
+		binary.Write(&p, binary.BigEndian, math.Float64bits(float64(sm.time.Unix())))
+		p.WriteByte('G') // BINFLOAT
+		binary.Write(&p, binary.BigEndian, math.Float64bits(sm.value))
+		p.WriteString("\x86\x86") // TUPLE2, TUPLE2
+	}
+	p.WriteString("e.") // APPENDS, STOP
+
+	binary.Write(buf, binary.BigEndian, uint32(p.Len()))
+	p.WriteTo(buf)
+}
+
+// graphiteSanitize replaces characters which have special meaning in Graphite
+// paths.
+func graphiteSanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', ' ', '/', '\\', ';', '=':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// graphiteSamples returns samples from before and after 2038, when 32-bit
+// timestamps overflow.
+func graphiteSamples() []sample {
+	labels := []label{{"line_id", "12345"}}
+	return []sample{
+		{name: "aaisp_broadband_quota_remaining", labels: labels, value: 150e9, time: time.Unix(1718366400, 0)},
+		{name: "aaisp_broadband_tx_rate", labels: labels, value: 0.25, time: time.Unix(2208988800, 0)},
+	}
+}
+
+func TestGraphitePlaintext(t *testing.T) {
+	s := &graphiteSink{config: graphiteConfig{prefix: "aaisp"}}
+	var buf bytes.Buffer
+	s.plaintext(&buf, graphiteSamples())
+	want := "aaisp.broadband_quota_remaining.12345 150000000000 1718366400\n" +
+		"aaisp.broadband_tx_rate.12345 0.25 2208988800\n"
+	if buf.String() != want {
+		t.Errorf("got\n%q\nwant\n%q", buf.String(), want)
+	}
+}
+
+func TestGraphitePickle(t *testing.T) {
+	s := &graphiteSink{config: graphiteConfig{prefix: "aaisp"}}
+	var buf bytes.Buffer
+	s.pickle(&buf, graphiteSamples())
+	// pickle.loads gives
+	// [('aaisp.broadband_quota_remaining.12345', (1718366400.0, 150000000000.0)),
+	//  ('aaisp.broadband_tx_rate.12345', (2208988800.0, 0.25))]
+	want := "\x00\x00\x00\x7a" + // length
+		"\x80\x02](" + // PROTO 2, EMPTY_LIST, MARK
+		"X\x25\x00\x00\x00aaisp.broadband_quota_remaining.12345" +
+		"G\x41\xd9\x9b\x0c\x30\x00\x00\x00" + // 1718366400.0
+		"G\x42\x41\x76\x59\x2e\x00\x00\x00" + // 150000000000.0
+		"\x86\x86" +
+		"X\x1d\x00\x00\x00aaisp.broadband_tx_rate.12345" +
+		"G\x41\xe0\x75\x4f\xd0\x00\x00\x00" + // 2208988800.0
+		"G\x3f\xd0\x00\x00\x00\x00\x00\x00" + // 0.25
+		"\x86\x86" +
+		"e."
+	if buf.String() != want {
+		t.Errorf("got\n% x\nwant\n% x", buf.Bytes(), []byte(want))
+	}
+}
+
+func TestGraphitePath(t *testing.T) {
+	s := &graphiteSink{config: graphiteConfig{}}
+	sm := sample{name: "aaisp_broadband_line_info", labels: []label{{"line_id", "1"}, {"login", "line.one@a.1"}}}
+	if got, want := s.path(sm), "broadband_line_info.1.line_one@a_1"; got != want {
+		t.Errorf("path = %q, want %q", got, want)
+	}
+}
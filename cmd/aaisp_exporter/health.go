@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+	"github.com/rs/zerolog"
+)
+
+// readiness tracks whether the exporter has successfully validated its
+// credentials against the CHAOS API.
+type readiness struct {
+	mu     sync.Mutex
+	ready  bool
+	reason string
+}
+
+func newReadiness() *readiness {
+	return &readiness{reason: "credentials not yet validated"}
+}
+
+func (r *readiness) set(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ready = err == nil
+	if err != nil {
+		r.reason = err.Error()
+	}
+}
+
+// validate calls the API until it succeeds, retrying every interval. It gives
+// up if the API returns an error which won't go away by itself, such as for
+// invalid credentials.
+func (r *readiness) validate(src lineSource, interval time.Duration, log zerolog.Logger) {
+	for {
+		_, err := src.BroadbandInfo()
+		// A partial result still shows the credentials work.
+		var partial *chaos.PartialError
+		if err == nil || errors.As(err, &partial) {
+			r.set(nil)
+			log.Info().Msg("credentials validated")
+			return
+		}
+		r.set(fmt.Errorf("credential validation failed: %w", err))
+		var apiErr *chaos.APIError
+		if errors.As(err, &apiErr) && !apiErr.Retryable() {
+			log.Error().Err(err).Msg("credential validation failed; not retrying until the exporter is restarted")
+			return
+		}
+		log.Error().Err(err).Msg("credential validation failed")
+		time.Sleep(interval)
+	}
+}
+
+func (r *readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	ready, reason := r.ready, r.reason
+	r.mu.Unlock()
+	if !ready {
+		http.Error(w, reason, http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// runHealthcheck probes a running exporter's /healthz, returning the exit
+// status. It's for Docker's HEALTHCHECK, so images don't need curl. /healthz
+// isn't behind the bearer token or allow list, so neither is needed here.
+func runHealthcheck(args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "listen `address` of the exporter to check")
+	timeout := fs.Duration("timeout", 5*time.Second, "fail if there's no response within `duration`")
+	tlsCert := fs.String("web.tls-cert-file", "", "connect with HTTPS, expecting the certificate in `file`, if the exporter serves it")
+	fs.Parse(args)
+
+	host, port, err := net.SplitHostPort(*listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "healthcheck: invalid -listen address: %v\n", err)
+		return 1
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	client := &http.Client{Timeout: *timeout}
+	scheme := "http"
+	if *tlsCert != "" {
+		pinned, err := pinnedTLSConfig(*tlsCert)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "healthcheck: %v\n", err)
+			return 1
+		}
+		client.Transport = &http.Transport{TLSClientConfig: pinned}
+		scheme = "https"
+	}
+	resp, err := client.Get(scheme + "://" + net.JoinHostPort(host, port) + "/healthz")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "healthcheck: %v\n", err)
+		return 1
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "healthcheck: %s\n", resp.Status)
+		return 1
+	}
+	return 0
+}
+
+// pinnedTLSConfig returns a TLS configuration which only accepts the
+// certificate in certFile. The exporter is probed by address, which usually
+// isn't a name in its certificate, so the certificate is pinned instead of
+// verified.
+func pinnedTLSConfig(certFile string) (*tls.Config, error) {
+	b, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	var want []byte
+	for rest := b; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			want = block.Bytes
+			break
+		}
+	}
+	if want == nil {
+		return nil, fmt.Errorf("no certificate in %s", certFile)
+	}
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], want) {
+				return errors.New("the exporter's certificate isn't the one in -web.tls-cert-file")
+			}
+			return nil
+		},
+	}, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// haSensor describes how a metric is presented as a Home Assistant sensor.
+type haSensor struct {
+	name        string
+	deviceClass string
+	unit        string
+	// valueTemplate converts the published value for Home Assistant.
+	valueTemplate string
+}
+
+// haSensors maps metric names, without the "aaisp_broadband_" prefix, to
+// their Home Assistant presentation. Metrics not listed use their help text
+// as the name.
+var haSensors = map[string]haSensor{
+	"quota_remaining": {"Quota remaining", "data_size", "B", ""},
+	"quota_total":     {"Monthly quota", "data_size", "B", ""},
+	"tx_rate":         {"Download rate", "data_rate", "bit/s", ""},
+	"rx_rate":         {"Upload rate", "data_rate", "bit/s", ""},
+	// Timestamp sensors need an ISO 8601 time rather than Unix time.
+	"quota_reset_timestamp_seconds": {"Quota reset", "timestamp", "",
+		"{{ value | float | timestamp_custom('%Y-%m-%dT%H:%M:%S+00:00', false) }}"},
+}
+
+// haDevice groups a line's sensors into a single Home Assistant device.
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// haConfig is a Home Assistant MQTT discovery message for a sensor.
+type haConfig struct {
+	Name              string   `json:"name"`
+	UniqueID          string   `json:"unique_id"`
+	StateTopic        string   `json:"state_topic"`
+	DeviceClass       string   `json:"device_class,omitempty"`
+	UnitOfMeasurement string   `json:"unit_of_measurement,omitempty"`
+	ValueTemplate     string   `json:"value_template,omitempty"`
+	StateClass        string   `json:"state_class,omitempty"`
+	Device            haDevice `json:"device"`
+}
+
+// publishDiscovery publishes Home Assistant discovery messages for every line
+// metric in samples, so they appear as sensors grouped by line.
+func (s *mqttSink) publishDiscovery(c *mqttClient, samples []sample) error {
+	for _, sm := range samples {
+		id := sm.labelValue("line_id")
+		if id == "" {
+			continue
+		}
+		metric := strings.TrimPrefix(strings.TrimPrefix(sm.name, "aaisp_"), "broadband_")
+		sensor, ok := haSensors[metric]
+		if !ok {
+			sensor.name = sm.help
+		}
+		objectID := "aaisp_" + id + "_" + metric
+		config := haConfig{
+			Name:              sensor.name,
+			UniqueID:          objectID,
+			StateTopic:        s.topic(sm),
+			DeviceClass:       sensor.deviceClass,
+			UnitOfMeasurement: sensor.unit,
+			ValueTemplate:     sensor.valueTemplate,
+			StateClass:        "measurement",
+			Device: haDevice{
+				Identifiers:  []string{"aaisp_" + id},
+				Name:         "AAISP line " + id,
+				Manufacturer: "Andrews & Arnold",
+				Model:        "Broadband",
+			},
+		}
+		if sensor.deviceClass == "timestamp" {
+			// A time isn't a measurement, and Home Assistant rejects
+			// timestamp sensors with a state class.
+			config.StateClass = ""
+		}
+		b, err := json.Marshal(config)
+		if err != nil {
+			return err
+		}
+		topic := s.config.discoveryPrefix + "/sensor/" + objectID + "/config"
+		if err := c.publish(topic, b, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// influxConfig configures the InfluxDB sink.
+type influxConfig struct {
+	url      string
+	database string
+	username string
+	password string
+	org      string
+	bucket   string
+	token    string
+}
+
+func addInfluxFlags(fs *flag.FlagSet) *influxConfig {
+	c := new(influxConfig)
+	fs.StringVar(&c.url, "influx.url", "", "push metrics to the InfluxDB server at `URL`")
+	fs.StringVar(&c.database, "influx.database", "aaisp", "InfluxDB 1.x `database`")
+	fs.StringVar(&c.username, "influx.username", "", "InfluxDB 1.x `username`")
+	fs.StringVar(&c.password, "influx.password", "", "InfluxDB 1.x `password`")
+	fs.StringVar(&c.org, "influx.org", "", "InfluxDB 2.x `organisation`")
+	fs.StringVar(&c.bucket, "influx.bucket", "", "InfluxDB 2.x `bucket`; setting this uses the 2.x API")
+	fs.StringVar(&c.token, "influx.token", "", "InfluxDB 2.x API `token`")
+	return c
+}
+
+// sink returns the configured sink, or nil if InfluxDB isn't configured.
+func (c *influxConfig) sink() (sink, error) {
+	if c.url == "" {
+		return nil, nil
+	}
+	return &influxSink{config: *c, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// influxSink writes metrics to InfluxDB using the line protocol, with the
+// metric name as the measurement, labels as tags and a single "value" field.
+type influxSink struct {
+	config influxConfig
+	client *http.Client
+}
+
+func (s *influxSink) name() string { return "influxdb" }
+
+func (s *influxSink) push(samples []sample) error {
+	var buf bytes.Buffer
+	for _, sm := range samples {
+		buf.WriteString(influxEscape(sm.name, " ,"))
+		for _, l := range sm.labels {
+			fmt.Fprintf(&buf, ",%s=%s", influxEscape(l.name, " ,="), influxEscape(l.value, " ,="))
+		}
+		fmt.Fprintf(&buf, " value=%s %d\n", strconv.FormatFloat(sm.value, 'f', -1, 64), sm.time.UnixNano())
+	}
+
+	req, err := http.NewRequest("POST", s.writeURL(), &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	switch {
+	case s.config.bucket != "":
+		req.Header.Set("Authorization", "Token "+s.config.token)
+	case s.config.username != "":
+		req.SetBasicAuth(s.config.username, s.config.password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("bad response code: %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// writeURL returns the write endpoint for the configured API version.
+func (s *influxSink) writeURL() string {
+	q := url.Values{"precision": {"ns"}}
+	path := "/write"
+	if s.config.bucket != "" {
+		path = "/api/v2/write"
+		q.Set("org", s.config.org)
+		q.Set("bucket", s.config.bucket)
+	} else {
+		q.Set("db", s.config.database)
+	}
+	return strings.TrimSuffix(s.config.url, "/") + path + "?" + q.Encode()
+}
+
+// influxEscape escapes the given characters, and backslashes, with a backslash.
+func influxEscape(s, chars string) string {
+	if !strings.ContainsAny(s, chars+`\`) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if r == '\\' || strings.ContainsRune(chars, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
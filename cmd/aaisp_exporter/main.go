@@ -1,53 +1,95 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	chaos "github.com/jamesog/aaisp-chaos"
+	"github.com/jamesog/aaisp-chaos/credentials"
+	"github.com/jamesog/aaisp-chaos/vcr"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 )
 
+// metric defines one of the exporter's metrics. The Descs, the generated
+// dashboard and the alerting rules are all built from these, so they can't
+// drift apart.
+type metric struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (m metric) desc() *prometheus.Desc {
+	return prometheus.NewDesc(m.name, m.help, m.labels, nil)
+}
+
 var (
-	broadbandQuotaRemainingDesc = prometheus.NewDesc(
-		"aaisp_broadband_quota_remaining",
-		"Quota remaining in bytes",
-		[]string{"line_id"},
-		nil,
-	)
-	broadbandQuotaTotalDesc = prometheus.NewDesc(
-		"aaisp_broadband_quota_total",
-		"Quota total in bytes",
-		[]string{"line_id"},
-		nil,
-	)
-	broadbandTXRateDesc = prometheus.NewDesc(
-		"aaisp_broadband_tx_rate",
-		"Line transmit rate in bits per second",
-		[]string{"line_id"},
-		nil,
-	)
-	broadbandRXRateDesc = prometheus.NewDesc(
-		"aaisp_broadband_rx_rate",
-		"Line receive rate in bits per second",
-		[]string{"line_id"},
-		nil,
-	)
-	scrapeSuccessGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "aaisp_scrape_success",
-		Help: "Displays whether or not the AAISP API scrape was a success",
-	})
+	broadbandLineInfo = metric{
+		name:   "aaisp_broadband_line_info",
+		help:   "Details of the line, in labels; the value is always 1",
+		labels: []string{"line_id", "login", "postcode"},
+	}
+	broadbandQuotaRemaining = metric{
+		name:   "aaisp_broadband_quota_remaining",
+		help:   "Quota remaining in bytes",
+		labels: []string{"line_id"},
+	}
+	broadbandQuotaTotal = metric{
+		name:   "aaisp_broadband_quota_total",
+		help:   "Quota total in bytes",
+		labels: []string{"line_id"},
+	}
+	broadbandQuotaReset = metric{
+		name:   "aaisp_broadband_quota_reset_timestamp_seconds",
+		help:   "When the monthly quota is next reset, as a Unix timestamp",
+		labels: []string{"line_id"},
+	}
+	broadbandTXRate = metric{
+		name:   "aaisp_broadband_tx_rate",
+		help:   "Line transmit rate in bits per second",
+		labels: []string{"line_id"},
+	}
+	broadbandRXRate = metric{
+		name:   "aaisp_broadband_rx_rate",
+		help:   "Line receive rate in bits per second",
+		labels: []string{"line_id"},
+	}
+	scrapeSuccess = metric{
+		name: "aaisp_scrape_success",
+		help: "Displays whether or not the AAISP API scrape was a success",
+	}
+
+	broadbandLineInfoDesc       = broadbandLineInfo.desc()
+	broadbandQuotaRemainingDesc = broadbandQuotaRemaining.desc()
+	broadbandQuotaTotalDesc     = broadbandQuotaTotal.desc()
+	broadbandQuotaResetDesc     = broadbandQuotaReset.desc()
+	broadbandTXRateDesc         = broadbandTXRate.desc()
+	broadbandRXRateDesc         = broadbandRXRate.desc()
+	scrapeSuccessDesc           = scrapeSuccess.desc()
 )
 
 type broadbandCollector struct {
-	*chaos.API
+	lineSource
 	log zerolog.Logger
+	// ctx, if set, limits how long collecting may take.
+	ctx context.Context
+	// quotaOnly leaves out the metrics which need more than the quota
+	// endpoint returns.
+	quotaOnly bool
 }
 
 func (bc broadbandCollector) Describe(ch chan<- *prometheus.Desc) {
@@ -55,14 +97,35 @@ func (bc broadbandCollector) Describe(ch chan<- *prometheus.Desc) {
 }
 
 func (bc broadbandCollector) Collect(ch chan<- prometheus.Metric) {
-	lines, err := bc.BroadbandInfo()
-	if err != nil {
+	ctx := bc.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	lines, err := broadbandInfo(ctx, bc.lineSource)
+	var partial *chaos.PartialError
+	switch {
+	case errors.As(err, &partial):
+		// Still expose the lines which could be decoded, but flag the
+		// scrape as failed.
+		bc.log.Warn().Err(err).Int("lines", len(lines)).Msg("some broadband lines couldn't be decoded")
+		ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, 0)
+	case err != nil:
 		bc.log.Debug().Err(err).Msg("error getting broadband info")
-		scrapeSuccessGauge.Set(0)
+		ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, 0)
 		return
+	default:
+		bc.log.Debug().Int("lines", len(lines)).Msg("got broadband info")
+		ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, 1)
 	}
-	scrapeSuccessGauge.Set(1)
 	for _, line := range lines {
+		if !bc.quotaOnly {
+			ch <- prometheus.MustNewConstMetric(
+				broadbandLineInfoDesc,
+				prometheus.GaugeValue,
+				1,
+				strconv.Itoa(line.ID), line.Login, line.Postcode,
+			)
+		}
 		ch <- prometheus.MustNewConstMetric(
 			broadbandQuotaRemainingDesc,
 			prometheus.GaugeValue,
@@ -75,6 +138,21 @@ func (bc broadbandCollector) Collect(ch chan<- prometheus.Metric) {
 			float64(line.QuotaMonthly),
 			strconv.Itoa(line.ID),
 		)
+		// The reset follows the month of the quota data, or of now if the
+		// API didn't say when that was.
+		quotaTime := line.QuotaTimestamp.Time
+		if quotaTime.IsZero() {
+			quotaTime = time.Now()
+		}
+		ch <- prometheus.MustNewConstMetric(
+			broadbandQuotaResetDesc,
+			prometheus.GaugeValue,
+			float64(chaos.QuotaReset(quotaTime).Unix()),
+			strconv.Itoa(line.ID),
+		)
+		if bc.quotaOnly {
+			continue
+		}
 		ch <- prometheus.MustNewConstMetric(
 			broadbandTXRateDesc,
 			prometheus.GaugeValue,
@@ -97,7 +175,14 @@ func loggingMiddleware(log zerolog.Logger) func(next http.Handler) http.Handler
 			if err != nil {
 				remoteHost = r.RemoteAddr
 			}
-			log.Log().
+			id := r.Header.Get("X-Request-ID")
+			if id == "" {
+				id = newRequestID()
+			}
+			w.Header().Set("X-Request-ID", id)
+			reqLog := log.With().Str("request_id", id).Logger()
+			r = r.WithContext(reqLog.WithContext(r.Context()))
+			reqLog.Log().
 				Str("proto", r.Proto).
 				Str("method", r.Method).
 				Str("path", r.URL.Path).
@@ -110,6 +195,56 @@ func loggingMiddleware(log zerolog.Logger) func(next http.Handler) http.Handler
 	}
 }
 
+// newRequestID returns a random identifier for correlating log lines.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// uncheckedCollector hides the descriptors of a Collector so that registering
+// it doesn't call Collect, which for broadbandCollector means an API call.
+type uncheckedCollector struct {
+	prometheus.Collector
+}
+
+func (uncheckedCollector) Describe(chan<- *prometheus.Desc) {}
+
+// metricsHandler serves metrics using a registry created for each scrape, so
+// that any logging from the collector carries the request's ID.
+//
+// If Prometheus sends its scrape timeout, API requests are given up
+// timeoutOffset before it, so the exporter's own metrics and a failed
+// aaisp_scrape_success are still returned in time. If retryBudget is
+// positive, the scrape's API requests may make at most that many retries
+// between them.
+func metricsHandler(collector broadbandCollector, gatherer filterGatherer, timeoutOffset time.Duration, retryBudget int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := collector
+		c.log = *zerolog.Ctx(r.Context())
+		c.ctx = r.Context()
+		if retryBudget > 0 {
+			c.ctx = chaos.WithRetryBudget(c.ctx, chaos.NewRetryBudget(retryBudget, 0))
+		}
+		if s, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64); err == nil {
+			timeout := time.Duration(s*float64(time.Second)) - timeoutOffset
+			if timeout <= 0 {
+				timeout = time.Duration(s * float64(time.Second))
+			}
+			ctx, cancel := context.WithTimeout(c.ctx, timeout)
+			defer cancel()
+			c.ctx = ctx
+		}
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(uncheckedCollector{c})
+		g := gatherer
+		g.Gatherer = prometheus.Gatherers{gatherer.Gatherer, reg}
+		promhttp.HandlerFor(g, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
 func usage(fs *flag.FlagSet) func() {
 	return func() {
 		o := fs.Output()
@@ -124,43 +259,230 @@ func usage(fs *flag.FlagSet) func() {
 		})
 		fmt.Fprint(o, "\n\nOptions:\n")
 		fs.PrintDefaults()
-		fmt.Fprint(o, "\nThe environment variables CHAOS_CONTROL_LOGIN and CHAOS_CONTROL_PASSWORD must be set.\n")
+		fmt.Fprint(o, `
+Credentials are read from one of:
+  -chaos.credentials-file  an age-encrypted file, decrypted with CHAOS_AGE_IDENTITY_FILE or CHAOS_AGE_PASSPHRASE
+  -vault.path              a HashiCorp Vault KV v2 secret
+  -aws.secret              an AWS Secrets Manager secret
+  -gcp.secret              a Google Cloud Secret Manager secret
+  CHAOS_CONTROL_LOGIN and CHAOS_CONTROL_PASSWORD environment variables
+  systemd credentials in CREDENTIALS_DIRECTORY, if the environment variables aren't set
+`)
+	}
+}
+
+// stringList is a flag.Value which may be given multiple times.
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
 	}
+	return strings.Join(*l, ",")
 }
 
-func setupLogger(level, output string) zerolog.Logger {
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// withoutFlag returns args with the named flag and its value removed.
+func withoutFlag(args []string, name string) []string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		a := strings.TrimPrefix(strings.TrimPrefix(args[i], "-"), "-")
+		switch {
+		case a == name:
+			i++ // skip the value too
+		case strings.HasPrefix(a, name+"="):
+		default:
+			out = append(out, args[i])
+		}
+	}
+	return out
+}
+
+// awsRegion returns the region of the secret, from its ARN if it's given as
+// one, or else from the standard AWS environment variables.
+func awsRegion(secret string) string {
+	if parts := strings.Split(secret, ":"); len(parts) > 3 && parts[0] == "arn" {
+		return parts[3]
+	}
+	if r := os.Getenv("AWS_REGION"); r != "" {
+		return r
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+func setupLogger(w io.Writer, level, output string) zerolog.Logger {
 	ll, err := zerolog.ParseLevel(level)
 	if err != nil {
 		ll = zerolog.InfoLevel
 	}
 
-	log := zerolog.New(os.Stderr).Level(ll).With().Timestamp().Logger()
+	log := zerolog.New(w).Level(ll).With().Timestamp().Logger()
 
 	switch output {
 	case "console":
-		log = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+		log = log.Output(zerolog.ConsoleWriter{Out: w})
 	}
 
 	return log
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		os.Exit(runHealthcheck(os.Args[2:]))
+	}
+
 	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 	fs.Usage = usage(fs)
 	var (
-		listen    = fs.String("listen", ":8080", "listen `address`")
-		logLevel  = fs.String("log.level", "info", "log `level`")
-		logOutput = fs.String("log.output", "json", "log output `style` (json, console)")
+		listen      = fs.String("listen", ":8080", "listen `address`")
+		logLevel    = fs.String("log.level", "info", "log `level`")
+		logOutput   = fs.String("log.output", "json", "log output `style` (json, console)")
+		cacheTTL    = fs.Duration("cache.ttl", 0, "cache API responses for `duration` (0 disables caching)")
+		discovery   = fs.Duration("poll.discovery-interval", 0, "poll the line list in the background every `interval` (0 polls on each scrape)")
+		quotaPoll   = fs.Duration("poll.quota-interval", 0, "when polling in the background, refresh quotas every `interval`")
+		keepMetrics = fs.String("metrics.keep", "", "only expose metric names matching `regex`")
+		dropMetrics = fs.String("metrics.drop", "", "don't expose metric names matching `regex`")
+		quotaOnly   = fs.Bool("metrics.quota-only", false, "only expose quota metrics, using the smaller quota API response")
+		token       = fs.String("web.bearer-token", "", "require `token` as a bearer token for /metrics")
+		tokenFile   = fs.String("web.bearer-token-file", "", "read the bearer token for /metrics from `file`")
+		scrapeGrace = fs.Duration("web.timeout-offset", 500*time.Millisecond, "give up API requests `duration` before Prometheus's scrape timeout")
+		rateLimit   = fs.Float64("web.rate-limit", 0, "limit each client address to `rate` requests per second (0 disables)")
+		rateBurst   = fs.Int("web.rate-burst", 5, "allow bursts of up to `n` requests per client address")
+		tlsCert     = fs.String("web.tls-cert-file", "", "serve HTTPS with the certificate in `file`, reloading it when it changes")
+		tlsKey      = fs.String("web.tls-key-file", "", "read the HTTPS certificate's private key from `file`")
+		enableAPI   = fs.Bool("web.api", false, "serve a read-only JSON API of line data under /lines")
+		enableFeed  = fs.Bool("web.feed", false, "serve an Atom feed of line events at /feed.atom")
+		genDash     = fs.Bool("generate-dashboard", false, "print a Grafana dashboard for the exposed metrics and exit")
+		genRules    = fs.Bool("generate-rules", false, "print Prometheus alerting rules for the exposed metrics and exit")
+		quotaLow    = fs.Float64("rules.quota-low", 10, "alert when less than `percent` of the monthly quota remains (0 disables)")
+		ruleFor     = fs.Duration("rules.for", 15*time.Minute, "how long a condition must hold before alerting")
+		ruleStale   = fs.Duration("rules.stale", 30*time.Minute, "alert when cached data is older than `duration`")
+		idleTimeout = fs.Duration("chaos.idle-timeout", 90*time.Second, "keep idle API connections open for `duration` between requests (0 closes them after each request)")
+		retries     = fs.Int("chaos.retries", 0, "retry failed API requests up to `n` times on each endpoint")
+		retryBudget = fs.Int("chaos.retry-budget", 10, "allow at most `n` API retries per minute in total")
+		scrapeRetry = fs.Int("chaos.scrape-retry-budget", 2, "allow at most `n` API retries per scrape (0 for no limit)")
+		readOnly    = fs.Bool("chaos.read-only", true, "refuse API calls which might make changes, such as buying top-ups")
+		apiTimeout  = fs.Duration("chaos.timeout", 10*time.Second, "limit each API request to `duration` in total")
+		dialTimeout = fs.Duration("chaos.dial-timeout", 30*time.Second, "limit opening a connection to the API to `duration`")
+		tlsTimeout  = fs.Duration("chaos.tls-timeout", 10*time.Second, "limit the TLS handshake with the API to `duration`")
+		respTimeout = fs.Duration("chaos.response-header-timeout", 0, "limit waiting for API response headers to `duration` (0 leaves only -chaos.timeout)")
+		sourceAddr  = fs.String("chaos.source-address", "", "connect to the API from local `address`, e.g. to use a particular WAN link")
+		bindIface   = fs.String("chaos.interface", "", "connect to the API through network `interface`, e.g. ppp0 (Linux only)")
+		transport   = fs.Bool("metrics.transport", false, "expose metrics for connections to the API, such as DNS and TLS handshake times")
+		credsFile   = fs.String("chaos.credentials-file", "", "read credentials from age-encrypted `file` instead of the environment")
+		vaultPath   = fs.String("vault.path", "", "read credentials from the Vault KV v2 secret at `path`, using VAULT_ADDR and VAULT_TOKEN or VAULT_ROLE_ID and VAULT_SECRET_ID")
+		vaultMount  = fs.String("vault.mount", "secret", "`path` the Vault KV v2 secrets engine is mounted at")
+		awsSecret   = fs.String("aws.secret", "", "read credentials from the AWS Secrets Manager secret with `name` or ARN")
+		gcpSecret   = fs.String("gcp.secret", "", "read credentials from the Google Cloud Secret Manager secret `name`, e.g. projects/PROJECT/secrets/SECRET")
+		vcrRecord   = fs.String("vcr.record", "", "record API responses to cassette `file`")
+		vcrReplay   = fs.String("vcr.replay", "", "answer API requests from cassette `file` instead of calling the API")
+		startTime   = fs.String("debug.start-time", "", "pretend the exporter started at `time` (RFC 3339), e.g. to simulate the monthly quota reset")
+		service     = fs.String("service", "", "`action` to take on the Windows service (install, uninstall), then exit")
+		pushEvery   = fs.Duration("push.interval", time.Minute, "push metrics to configured sinks every `interval`")
+		endpoints   stringList
+		dropLabels  stringList
+		allowCIDRs  stringList
 	)
+	var (
+		influx     = addInfluxFlags(fs)
+		graphite   = addGraphiteFlags(fs)
+		mqtt       = addMQTTFlags(fs)
+		statsd     = addStatsDFlags(fs)
+		datadog    = addDatadogFlags(fs)
+		cloudwatch = addCloudWatchFlags(fs)
+		vm         = addVMFlags(fs)
+		notify     = addNotifyFlags(fs)
+	)
+	fs.Var(&endpoints, "chaos.endpoint", "CHAOS API `URL`; may be repeated to list failover endpoints in order of preference")
+	fs.Var(&dropLabels, "metrics.drop-label", "remove `label` from all metrics; may be repeated")
+	fs.Var(&allowCIDRs, "web.allow-cidr", "only serve metrics and line data to clients in `CIDR`; may be repeated")
 	fs.Parse(os.Args[1:])
 
-	log := setupLogger(*logLevel, *logOutput)
+	logWriter := &redactWriter{w: os.Stderr}
+	log := setupLogger(logWriter, *logLevel, *logOutput)
+	if *service != "" {
+		if err := controlService(*service, withoutFlag(os.Args[1:], "service")); err != nil {
+			log.Fatal().Err(err).Msg("error controlling service")
+		}
+		return
+	}
+	inService := isService()
+	if inService {
+		w, err := newServiceLogWriter()
+		if err != nil {
+			log.Fatal().Err(err).Msg("error opening event log")
+		}
+		logWriter.w = w
+		log = log.Output(logWriter)
+	}
+
+	gatherer := filterGatherer{
+		Gatherer:   prometheus.DefaultGatherer,
+		dropLabels: make(map[string]bool),
+	}
+	var err error
+	if gatherer.keep, err = compileAnchored(*keepMetrics); err != nil {
+		log.Fatal().Err(err).Msg("invalid -metrics.keep")
+	}
+	if gatherer.drop, err = compileAnchored(*dropMetrics); err != nil {
+		log.Fatal().Err(err).Msg("invalid -metrics.drop")
+	}
+	for _, l := range dropLabels {
+		gatherer.dropLabels[l] = true
+	}
+
+	if *genDash {
+		panels := broadbandPanels
+		if *quotaOnly {
+			panels = quotaPanels
+		}
+		if *cacheTTL > 0 && *discovery == 0 {
+			panels = append(panels, cachePanels...)
+		}
+		if err := generateDashboard(os.Stdout, gatherer, panels); err != nil {
+			log.Fatal().Err(err).Msg("couldn't generate dashboard")
+		}
+		return
+	}
+	if *genRules {
+		cfg := ruleConfig{
+			quotaLow:  *quotaLow,
+			duration:  *ruleFor,
+			stale:     *ruleStale,
+			cache:     *cacheTTL > 0 && *discovery == 0,
+			quotaOnly: *quotaOnly,
+		}
+		if err := generateRules(os.Stdout, gatherer, cfg); err != nil {
+			log.Fatal().Err(err).Msg("couldn't generate rules")
+		}
+		return
+	}
+
+	var clk clock = realClock{}
+	if *startTime != "" {
+		t, err := time.Parse(time.RFC3339, *startTime)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid -debug.start-time")
+		}
+		clk = offsetClock{offset: time.Until(t)}
+		log.Warn().Time("now", clk.Now()).Msg("using a simulated clock")
+	}
 
 	var (
 		controlLogin    = os.Getenv("CHAOS_CONTROL_LOGIN")
 		controlPassword = os.Getenv("CHAOS_CONTROL_PASSWORD")
+		// systemd credentials are used if the environment doesn't have any.
+		systemdCreds = controlLogin == "" && controlPassword == "" && os.Getenv("CREDENTIALS_DIRECTORY") != ""
 	)
 	switch {
+	case *vcrReplay != "":
+		// Replayed requests don't need credentials.
+	case *credsFile != "", *vaultPath != "", *awsSecret != "", *gcpSecret != "", systemdCreds:
+		// Read below.
 	case controlLogin == "" && controlPassword == "":
 		log.Fatal().Msg("CHAOS_CONTROL_LOGIN and CHAOS_CONTROL_PASSWORD must be set in the environment")
 	case controlLogin == "":
@@ -169,19 +491,259 @@ func main() {
 		log.Fatal().Msg("CHAOS_CONTROL_PASSWORD is not set")
 	}
 
+	auth := chaos.Auth{
+		ControlLogin:    controlLogin,
+		ControlPassword: controlPassword,
+	}
+	if *credsFile != "" {
+		f := credentials.AgeFile{
+			Path:         *credsFile,
+			IdentityFile: os.Getenv("CHAOS_AGE_IDENTITY_FILE"),
+		}
+		if pass := os.Getenv("CHAOS_AGE_PASSPHRASE"); pass != "" {
+			f.Passphrase = func() (string, error) { return pass, nil }
+		}
+		if auth, err = f.Credentials(context.Background()); err != nil {
+			log.Fatal().Err(err).Msg("couldn't read credentials file")
+		}
+	}
+	if systemdCreds && *credsFile == "" {
+		if auth, err = (credentials.Systemd{}).Credentials(context.Background()); err != nil {
+			log.Fatal().Err(err).Msg("couldn't read systemd credentials")
+		}
+	}
+	var provider chaos.CredentialProvider
+	// Secret stores keep using the credentials already read if they can't
+	// be read again.
+	refreshError := func(err error) {
+		log.Warn().Err(err).Msg("couldn't read credentials again; using those read before")
+	}
+	if *vaultPath != "" {
+		provider = &credentials.Vault{
+			Address:   os.Getenv("VAULT_ADDR"),
+			Namespace: os.Getenv("VAULT_NAMESPACE"),
+			Mount:     *vaultMount,
+			Path:      *vaultPath,
+			Token:     os.Getenv("VAULT_TOKEN"),
+			RoleID:    os.Getenv("VAULT_ROLE_ID"),
+			SecretID:  os.Getenv("VAULT_SECRET_ID"),
+
+			OnRefreshError: refreshError,
+		}
+	}
+	if *awsSecret != "" {
+		provider = &credentials.AWSSecretsManager{
+			Region:          awsRegion(*awsSecret),
+			SecretID:        *awsSecret,
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+
+			OnRefreshError: refreshError,
+		}
+	}
+	if *gcpSecret != "" {
+		token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+		if token != "" {
+			log.Warn().Msg("GOOGLE_OAUTH_ACCESS_TOKEN isn't refreshed, so the secret can't be read again once it expires; unset it to use the metadata server")
+		}
+		provider = &credentials.GCPSecretManager{
+			Secret: *gcpSecret,
+			Token:  token,
+
+			OnRefreshError: refreshError,
+		}
+	}
+	if provider != nil {
+		// Fail now rather than on the first scrape if the secret can't be
+		// read. The provider is used for every request after this, so
+		// rotated credentials are picked up.
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		auth, err = provider.Credentials(ctx)
+		cancel()
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't read credentials")
+		}
+	}
+	api := chaos.New(auth)
+	if provider != nil {
+		api.Credentials = provider
+	}
+	if len(endpoints) > 0 {
+		api.Endpoint = endpoints[0]
+		api.Fallback = endpoints[1:]
+	}
+	api.OnDecodeWarning = func(w chaos.DecodeWarning) {
+		log.Warn().Err(w).Msg("ignoring invalid field in API response")
+	}
+	api.ReadOnly = *readOnly
+	api.Timeout = *apiTimeout
+	api.Retries = *retries
+	api.RetryBudget = chaos.NewRetryBudget(*retryBudget, time.Minute)
+	opts := chaos.TransportOptions{
+		IdleTimeout:           *idleTimeout,
+		DialTimeout:           *dialTimeout,
+		TLSHandshakeTimeout:   *tlsTimeout,
+		ResponseHeaderTimeout: *respTimeout,
+		Interface:             *bindIface,
+	}
+	if *sourceAddr != "" {
+		if opts.LocalAddr = net.ParseIP(*sourceAddr); opts.LocalAddr == nil {
+			log.Fatal().Str("address", *sourceAddr).Msg("invalid -chaos.source-address")
+		}
+	}
+	if *idleTimeout == 0 {
+		opts.IdleTimeout = -1
+	}
+	api.Transport = chaos.NewTransport(opts)
+	if *transport {
+		m := newTransportMetrics()
+		m.register(prometheus.DefaultRegisterer)
+		api.Transport = m.instrument(api.Transport.(*http.Transport))
+	}
+	switch {
+	case *vcrReplay != "":
+		t, err := vcr.New(*vcrReplay, vcr.Replay)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't load cassette")
+		}
+		api.Transport = t
+	case *vcrRecord != "":
+		t, err := vcr.New(*vcrRecord, vcr.Record)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't create cassette")
+		}
+		t.Transport = api.Transport
+		api.Transport = t
+	}
+
 	collector := broadbandCollector{
-		API: chaos.New(chaos.Auth{
-			ControlLogin:    controlLogin,
-			ControlPassword: controlPassword,
-		}),
-		log: log,
+		lineSource: api,
+		log:        log,
+		quotaOnly:  *quotaOnly,
+	}
+	if *quotaOnly {
+		if *discovery > 0 {
+			log.Fatal().Msg("-metrics.quota-only can't be used with background polling; use -poll.quota-interval to refresh quotas with the quota API instead")
+		}
+		collector.lineSource = quotaLines{api}
+	}
+	switch {
+	case *discovery > 0:
+		// The poller has its own copy of the API so that only its requests
+		// are conditional.
+		pollAPI := *api
+		pollAPI.Validators = new(chaos.Validators)
+		p := &poller{
+			api:               &pollAPI,
+			discoveryInterval: *discovery,
+			quotaInterval:     *quotaPoll,
+			log:               log,
+		}
+		go p.run()
+		collector.lineSource = p
+	case *cacheTTL > 0:
+		cache := &infoCache{lineSource: collector.lineSource, ttl: *cacheTTL, clock: clk}
+		cache.register(prometheus.DefaultRegisterer)
+		collector.lineSource = cache
+	}
+
+	var sinks []sink
+	for _, c := range []sinkConfig{influx, graphite, mqtt, statsd, datadog, cloudwatch, vm} {
+		s, err := c.sink()
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid sink configuration")
+		}
+		if s != nil {
+			sinks = append(sinks, s)
+		}
+	}
+	notifiers := notify.notifiers(auth, api.Credentials)
+	bot, err := notify.telegramBot(collector.lineSource, log)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid Telegram configuration")
+	}
+	if bot != nil {
+		notifiers = append(notifiers, bot)
+		go bot.run()
+	}
+	var feed *feedNotifier
+	if *enableFeed {
+		feed = new(feedNotifier)
+		notifiers = append(notifiers, feed)
+	}
+	w, err := notify.watcher(notifiers, log)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid notification configuration")
 	}
+	var unfiltered []sink
+	if w != nil {
+		unfiltered = append(unfiltered, w)
+	}
+	if len(sinks) > 0 || len(unfiltered) > 0 {
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(uncheckedCollector{collector})
+		go runSinks(sinks, unfiltered, reg, gatherer, *pushEvery, clk, log)
+	}
+
+	ready := newReadiness()
+	go ready.validate(api, 30*time.Second, log)
 
 	loggedHandler := loggingMiddleware(log)
 
-	prometheus.MustRegister(collector)
-	prometheus.MustRegister(scrapeSuccessGauge)
-	http.Handle("/metrics", loggedHandler(promhttp.Handler()))
+	if *tokenFile != "" {
+		if *token, err = readToken(*tokenFile); err != nil {
+			log.Fatal().Err(err).Msg("error reading bearer token file")
+		}
+	}
+	allowed, err := parseAllowList(allowCIDRs)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid -web.allow-cidr")
+	}
+	// protect restricts the handlers serving line data. The health checks
+	// are left open for probes.
+	protect := func(h http.Handler) http.Handler {
+		return loggedHandler(restrict(*token, allowed)(h))
+	}
+
+	http.Handle("/metrics", protect(promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		metricsHandler(collector, gatherer, *scrapeGrace, *scrapeRetry),
+	)))
+	if *enableAPI {
+		handleAPI := protect(apiHandler{src: collector.lineSource})
+		http.Handle("/lines", handleAPI)
+		http.Handle("/lines/", handleAPI)
+	}
+	if feed != nil {
+		http.Handle("/feed.atom", protect(feed))
+	}
+	http.HandleFunc("/healthz", healthz)
+	http.Handle("/readyz", ready)
+	var handler http.Handler = http.DefaultServeMux
+	if *rateLimit > 0 {
+		handler = newIPLimiter(*rateLimit, *rateBurst).middleware(handler)
+	}
 	log.Info().Msgf("Listening on %s", *listen)
-	log.Fatal().Err(http.ListenAndServe(*listen, nil)).Send()
+	serve := func() error { return http.ListenAndServe(*listen, handler) }
+	if *tlsCert != "" || *tlsKey != "" {
+		certs, err := newCertReloader(*tlsCert, *tlsKey, log)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't load TLS certificate")
+		}
+		go certs.watch(30 * time.Second)
+		srv := &http.Server{
+			Addr:      *listen,
+			Handler:   handler,
+			TLSConfig: &tls.Config{GetCertificate: certs.getCertificate},
+		}
+		serve = func() error { return srv.ListenAndServeTLS("", "") }
+	}
+	if inService {
+		if err := runService(serve); err != nil {
+			log.Fatal().Err(err).Send()
+		}
+		return
+	}
+	log.Fatal().Err(serve()).Send()
 }
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mqttConfig configures the MQTT sink.
+type mqttConfig struct {
+	broker   string
+	clientID string
+	username string
+	password string
+	prefix   string
+	retain   bool
+
+	homeAssistant   bool
+	discoveryPrefix string
+}
+
+func addMQTTFlags(fs *flag.FlagSet) *mqttConfig {
+	c := new(mqttConfig)
+	fs.StringVar(&c.broker, "mqtt.broker", "", "publish metrics to the MQTT broker at `URL` (tcp://host:1883 or ssl://host:8883)")
+	fs.StringVar(&c.clientID, "mqtt.client-id", "aaisp_exporter", "MQTT client `ID`")
+	fs.StringVar(&c.username, "mqtt.username", "", "MQTT `username`")
+	fs.StringVar(&c.password, "mqtt.password", "", "MQTT `password`")
+	fs.StringVar(&c.prefix, "mqtt.topic-prefix", "aaisp", "`prefix` for MQTT topics")
+	fs.BoolVar(&c.retain, "mqtt.retain", true, "publish MQTT messages with the retain flag")
+	fs.BoolVar(&c.homeAssistant, "mqtt.homeassistant", false, "publish Home Assistant MQTT discovery messages")
+	fs.StringVar(&c.discoveryPrefix, "mqtt.homeassistant-prefix", "homeassistant", "Home Assistant discovery topic `prefix`")
+	return c
+}
+
+func (c *mqttConfig) sink() (sink, error) {
+	if c.broker == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(c.broker)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "tcp", "mqtt", "ssl", "tls", "mqtts":
+	default:
+		return nil, fmt.Errorf("unsupported MQTT broker scheme %q", u.Scheme)
+	}
+	return &mqttSink{config: *c, url: u}, nil
+}
+
+// mqttSink publishes each metric to its own topic, named from the prefix, the
+// line ID and the metric name without its "aaisp_broadband_" prefix, e.g.
+// "aaisp/12345/quota_remaining". Metrics which aren't about a line are
+// published directly under the prefix.
+type mqttSink struct {
+	config mqttConfig
+	url    *url.URL
+}
+
+func (s *mqttSink) name() string { return "mqtt" }
+
+func (s *mqttSink) topic(sm sample) string {
+	name := strings.TrimPrefix(strings.TrimPrefix(sm.name, "aaisp_"), "broadband_")
+	if id := sm.labelValue("line_id"); id != "" {
+		return s.config.prefix + "/" + id + "/" + name
+	}
+	return s.config.prefix + "/" + name
+}
+
+func (s *mqttSink) push(samples []sample) error {
+	c, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	if s.config.homeAssistant {
+		if err := s.publishDiscovery(c, samples); err != nil {
+			return err
+		}
+	}
+	for _, sm := range samples {
+		payload := strconv.FormatFloat(sm.value, 'f', -1, 64)
+		if err := c.publish(s.topic(sm), []byte(payload), s.config.retain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *mqttSink) dial() (*mqttClient, error) {
+	useTLS := false
+	port := "1883"
+	switch s.url.Scheme {
+	case "ssl", "tls", "mqtts":
+		useTLS = true
+		port = "8883"
+	}
+	addr := s.url.Host
+	if s.url.Port() == "" {
+		addr = net.JoinHostPort(s.url.Hostname(), port)
+	}
+	return mqttDial(addr, useTLS, s.config.clientID, s.config.username, s.config.password)
+}
+
+// mqttClient is a minimal MQTT 3.1.1 client which can only publish messages
+// at QoS 0, which is all the exporter needs.
+type mqttClient struct {
+	conn net.Conn
+}
+
+func mqttDial(addr string, useTLS bool, clientID, username, password string) (*mqttClient, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, nil)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	c := &mqttClient{conn: conn}
+
+	var flags byte = 0x02 // clean session
+	var payload bytes.Buffer
+	mqttString(&payload, clientID)
+	if username != "" {
+		flags |= 0x80
+		mqttString(&payload, username)
+		if password != "" {
+			flags |= 0x40
+			mqttString(&payload, password)
+		}
+	}
+	var connect bytes.Buffer
+	mqttString(&connect, "MQTT")
+	connect.Write([]byte{4, flags, 0, 60}) // protocol level 4, keep alive 60s
+	payload.WriteTo(&connect)
+	if err := c.write(0x10, connect.Bytes()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	connack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, connack); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading CONNACK: %w", err)
+	}
+	if connack[0] != 0x20 {
+		conn.Close()
+		return nil, errors.New("expected CONNACK from MQTT broker")
+	}
+	if rc := connack[3]; rc != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("MQTT broker refused connection: return code %d", rc)
+	}
+	return c, nil
+}
+
+func (c *mqttClient) publish(topic string, payload []byte, retain bool) error {
+	var header byte = 0x30
+	if retain {
+		header |= 0x01
+	}
+	var b bytes.Buffer
+	mqttString(&b, topic)
+	b.Write(payload)
+	return c.write(header, b.Bytes())
+}
+
+// close sends DISCONNECT and closes the connection.
+func (c *mqttClient) close() error {
+	c.conn.Write([]byte{0xe0, 0})
+	return c.conn.Close()
+}
+
+// write writes a control packet with the given fixed header byte.
+func (c *mqttClient) write(header byte, body []byte) error {
+	b := []byte{header}
+	// Remaining length is encoded 7 bits at a time, least significant first.
+	n := len(body)
+	for {
+		d := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := c.conn.Write(append(b, body...))
+	return err
+}
+
+func mqttString(b *bytes.Buffer, s string) {
+	b.Write([]byte{byte(len(s) >> 8), byte(len(s))})
+	b.WriteString(s)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeBroker accepts one MQTT connection, acknowledges its CONNECT and
+// returns everything the client sent, once it closes the connection.
+func fakeBroker(t *testing.T) (addr string, received <-chan []byte) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	ch := make(chan []byte, 1)
+	go func() {
+		defer close(ch)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var got bytes.Buffer
+		r := bufio.NewReader(io.TeeReader(conn, &got))
+		// Read the CONNECT packet before acknowledging it.
+		if _, err := r.ReadByte(); err != nil {
+			return
+		}
+		n, shift := 0, 0
+		for {
+			d, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			n |= int(d&0x7f) << shift
+			shift += 7
+			if d&0x80 == 0 {
+				break
+			}
+		}
+		if _, err := io.ReadFull(r, make([]byte, n)); err != nil {
+			return
+		}
+		conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		io.Copy(io.Discard, r)
+		ch <- got.Bytes()
+	}()
+	return ln.Addr().String(), ch
+}
+
+func TestMQTTFrames(t *testing.T) {
+	addr, received := fakeBroker(t)
+	c, err := mqttDial(addr, false, "exp", "u", "p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.publish("aaisp/12345/quota_remaining", []byte("150000000000"), true); err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("x", 200)
+	if err := c.publish("t", []byte(long), false); err != nil {
+		t.Fatal(err)
+	}
+	c.close()
+
+	want := "" +
+		// CONNECT, remaining length 21
+		"\x10\x15" +
+		"\x00\x04MQTT\x04" + // protocol name and level
+		"\xc2" + // username, password, clean session
+		"\x00\x3c" + // keep alive 60s
+		"\x00\x03exp" + "\x00\x01u" + "\x00\x01p" +
+		// PUBLISH, retained, remaining length 41
+		"\x31\x29" +
+		"\x00\x1baaisp/12345/quota_remaining" +
+		"150000000000" +
+		// PUBLISH, remaining length 203 as a two byte varint
+		"\x30\xcb\x01" +
+		"\x00\x01t" + long +
+		// DISCONNECT
+		"\xe0\x00"
+	if got := <-received; string(got) != want {
+		t.Errorf("got\n% x\nwant\n% x", got, []byte(want))
+	}
+}
+
+func TestMQTTRemainingLength(t *testing.T) {
+	tests := []struct {
+		n    int
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xff, 0x7f}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+	}
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		c := &mqttClient{conn: client}
+		go func() {
+			c.write(0x30, make([]byte, tt.n))
+			client.Close()
+		}()
+		got, err := io.ReadAll(server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) < 1+len(tt.want) || !bytes.Equal(got[1:1+len(tt.want)], tt.want) {
+			t.Errorf("remaining length %d encoded as % x, want % x", tt.n, got[1:min(len(got), 5)], tt.want)
+			continue
+		}
+		if len(got) != 1+len(tt.want)+tt.n {
+			t.Errorf("remaining length %d: wrote %d bytes, want %d", tt.n, len(got), 1+len(tt.want)+tt.n)
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+	"github.com/jamesog/aaisp-chaos/sms"
+	"github.com/rs/zerolog"
+)
+
+// Event types.
+const (
+	eventQuotaLow = "quota_low"
+	eventTopUp    = "topup"
+	eventResync   = "resync"
+	eventLineDown = "line_down"
+)
+
+// Event statuses. One-off events such as top-ups are always firing.
+const (
+	statusFiring   = "firing"
+	statusResolved = "resolved"
+)
+
+// event is a notable change to a line, sent to notifiers.
+type event struct {
+	Type             string    `json:"type"`
+	Status           string    `json:"status"`
+	LineID           string    `json:"line_id"`
+	Message          string    `json:"message"`
+	ThresholdPercent float64   `json:"threshold_percent,omitempty"`
+	QuotaRemaining   float64   `json:"quota_remaining"`
+	QuotaMonthly     float64   `json:"quota_monthly"`
+	TXRate           float64   `json:"tx_rate,omitempty"`
+	RXRate           float64   `json:"rx_rate,omitempty"`
+	Time             time.Time `json:"time"`
+	// PredictedExhaustion is when the quota will run out at the rate it's
+	// been used so far this month, if that's before the end of the month.
+	PredictedExhaustion *time.Time `json:"predicted_exhaustion,omitempty"`
+}
+
+// title returns a short description of the event for notification headings.
+func (e event) title() string {
+	var t string
+	switch e.Type {
+	case eventQuotaLow:
+		t = "Quota low"
+	case eventTopUp:
+		t = "Quota topped up"
+	case eventResync:
+		t = "Line resynced"
+	case eventLineDown:
+		t = "Line down"
+	default:
+		t = e.Type
+	}
+	if e.Status == statusResolved {
+		t += " (resolved)"
+	}
+	return t
+}
+
+// predictExhaustion returns when remaining quota will run out, assuming usage
+// continues at the average rate since the start of the month. It returns nil
+// if the quota will last the month.
+func predictExhaustion(remaining, monthly float64, now time.Time) *time.Time {
+	now = now.In(chaos.Location())
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	used := monthly - remaining
+	elapsed := now.Sub(start)
+	if used <= 0 || elapsed <= 0 {
+		return nil
+	}
+	perSecond := used / elapsed.Seconds()
+	t := now.Add(time.Duration(remaining/perSecond) * time.Second)
+	if !t.Before(start.AddDate(0, 1, 0)) {
+		return nil
+	}
+	return &t
+}
+
+// notifier delivers events somewhere.
+type notifier interface {
+	name() string
+	notify(e event) error
+}
+
+// notifyConfig configures notifications.
+type notifyConfig struct {
+	thresholds string
+	webhooks   stringList
+
+	slackWebhook string
+	slackChannel string
+
+	telegramAPI   string
+	telegramToken string
+	telegramChats stringList
+
+	discordWebhook string
+	discordEvents  string
+
+	ntfyServer   string
+	ntfyTopic    string
+	ntfyToken    string
+	ntfyUsername string
+	ntfyPassword string
+
+	smsTo     stringList
+	smsFrom   string
+	smsEvents string
+}
+
+func addNotifyFlags(fs *flag.FlagSet) *notifyConfig {
+	c := new(notifyConfig)
+	fs.StringVar(&c.thresholds, "notify.quota-thresholds", "20,10,5", "comma-separated remaining quota `percentages` which trigger notifications")
+	fs.Var(&c.webhooks, "notify.webhook", "POST JSON notifications to `URL`; may be repeated")
+	fs.StringVar(&c.slackWebhook, "notify.slack-webhook", "", "send notifications to the Slack incoming webhook `URL`")
+	fs.StringVar(&c.slackChannel, "notify.slack-channel", "", "override the Slack webhook's default `channel`")
+	fs.StringVar(&c.telegramToken, "notify.telegram-token", "", "Telegram bot `token`")
+	fs.Var(&c.telegramChats, "notify.telegram-chat", "Telegram chat `ID` to notify and accept commands from; may be repeated")
+	fs.StringVar(&c.discordWebhook, "notify.discord-webhook", "", "send notifications to the Discord webhook `URL`")
+	fs.StringVar(&c.discordEvents, "notify.discord-events", "", "comma-separated event `types` to send to Discord (default all)")
+	fs.StringVar(&c.ntfyTopic, "notify.ntfy-topic", "", "publish notifications to the ntfy `topic`")
+	fs.StringVar(&c.ntfyServer, "notify.ntfy-server", "https://ntfy.sh", "ntfy server `URL`")
+	fs.StringVar(&c.ntfyToken, "notify.ntfy-token", "", "ntfy access `token`")
+	fs.StringVar(&c.ntfyUsername, "notify.ntfy-username", "", "ntfy `username`")
+	fs.StringVar(&c.ntfyPassword, "notify.ntfy-password", "", "ntfy `password`")
+	fs.Var(&c.smsTo, "notify.sms-to", "send notifications by SMS through AAISP to `number`; may be repeated")
+	fs.StringVar(&c.smsFrom, "notify.sms-from", "", "SMS originator `number or name`")
+	fs.StringVar(&c.smsEvents, "notify.sms-events", eventQuotaLow, "comma-separated event `types` to send by SMS")
+	fs.StringVar(&c.telegramAPI, "notify.telegram-api-url", "https://api.telegram.org", "Telegram Bot API `URL`")
+	return c
+}
+
+// telegramBot returns the configured Telegram bot, or nil if it isn't
+// configured.
+func (c *notifyConfig) telegramBot(lines lineSource, log zerolog.Logger) (*telegramBot, error) {
+	if c.telegramToken == "" {
+		return nil, nil
+	}
+	if len(c.telegramChats) == 0 {
+		return nil, errors.New("-notify.telegram-chat is required with -notify.telegram-token")
+	}
+	var chats []int64
+	for _, s := range c.telegramChats {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Telegram chat ID %q", s)
+		}
+		chats = append(chats, id)
+	}
+	return newTelegramBot(c.telegramAPI, c.telegramToken, chats, lines, log), nil
+}
+
+// notifiers returns the configured notifiers. auth is used for the SMS
+// gateway, or provider's credentials at the time of each message if provider
+// isn't nil.
+func (c *notifyConfig) notifiers(auth chaos.Auth, provider chaos.CredentialProvider) []notifier {
+	var ns []notifier
+	for _, u := range c.webhooks {
+		ns = append(ns, newWebhookNotifier(u))
+	}
+	if c.slackWebhook != "" {
+		ns = append(ns, newSlackNotifier(c.slackWebhook, c.slackChannel))
+	}
+	if c.discordWebhook != "" {
+		ns = append(ns, newDiscordNotifier(c.discordWebhook, c.discordEvents))
+	}
+	if c.ntfyTopic != "" {
+		ns = append(ns, newNtfyNotifier(c.ntfyServer, c.ntfyTopic, c.ntfyToken, c.ntfyUsername, c.ntfyPassword))
+	}
+	if len(c.smsTo) > 0 {
+		client := sms.New(auth)
+		client.Credentials = provider
+		ns = append(ns, newSMSNotifier(client, c.smsTo, c.smsFrom, c.smsEvents))
+	}
+	return ns
+}
+
+// watcher returns a watcher sending events to notifiers, or nil if there are
+// none.
+func (c *notifyConfig) watcher(notifiers []notifier, log zerolog.Logger) (*watcher, error) {
+	if len(notifiers) == 0 {
+		return nil, nil
+	}
+	var thresholds []float64
+	for _, s := range strings.Split(c.thresholds, ",") {
+		if s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "%")); s == "" {
+			continue
+		}
+		t, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quota threshold %q", s)
+		}
+		thresholds = append(thresholds, t)
+	}
+	// Lowest first, so the first breached threshold is the most severe.
+	sort.Float64s(thresholds)
+	return &watcher{
+		thresholds: thresholds,
+		notifiers:  notifiers,
+		log:        log,
+		lines:      make(map[string]*lineState),
+	}, nil
+}
+
+// lineState is what the watcher remembers about a line between observations.
+type lineState struct {
+	remaining float64
+	txRate    float64
+	breached  float64 // lowest threshold breached, or 0 if none
+	month     time.Month
+}
+
+// watcher turns successive metric samples into events. It's a sink so that it
+// sees the same data as everything else on each push.
+type watcher struct {
+	thresholds []float64
+	notifiers  []notifier
+	log        zerolog.Logger
+
+	mu    sync.Mutex
+	lines map[string]*lineState
+}
+
+func (w *watcher) name() string { return "notify" }
+
+func (w *watcher) push(samples []sample) error {
+	lines := make(map[string]*lineSample)
+	var now time.Time
+	for _, sm := range samples {
+		id := sm.labelValue("line_id")
+		if id == "" {
+			continue
+		}
+		l, ok := lines[id]
+		if !ok {
+			l = new(lineSample)
+			lines[id] = l
+		}
+		switch sm.name {
+		case "aaisp_broadband_quota_remaining":
+			l.remaining = sm.value
+		case "aaisp_broadband_quota_total":
+			l.monthly = sm.value
+		case "aaisp_broadband_tx_rate":
+			l.txRate = sm.value
+		case "aaisp_broadband_rx_rate":
+			l.rxRate = sm.value
+		}
+		now = sm.time
+	}
+
+	w.mu.Lock()
+	var events []event
+	for id, l := range lines {
+		events = append(events, w.observe(id, *l, now)...)
+	}
+	w.mu.Unlock()
+
+	w.send(events)
+	return nil
+}
+
+// lineSample is a line's metric values from a single push.
+type lineSample struct {
+	remaining, monthly float64
+	txRate, rxRate     float64
+}
+
+// observe updates the state of a line and returns any resulting events.
+func (w *watcher) observe(id string, l lineSample, now time.Time) []event {
+	remaining, monthly := l.remaining, l.monthly
+	st, seen := w.lines[id]
+	if !seen {
+		st = &lineState{remaining: remaining, txRate: l.txRate, month: now.In(chaos.Location()).Month()}
+		w.lines[id] = st
+	}
+	base := event{LineID: id, QuotaRemaining: remaining, QuotaMonthly: monthly, TXRate: l.txRate, RXRate: l.rxRate, Time: now}
+	var events []event
+
+	// The sync rate only changes when the line resyncs, or drops to 0 when
+	// it goes down.
+	switch {
+	case !seen || l.txRate == st.txRate:
+	case l.txRate == 0:
+		e := base
+		e.Type, e.Status = eventLineDown, statusFiring
+		e.Message = fmt.Sprintf("Line %s is down (was %s down)", id, formatRate(st.txRate))
+		events = append(events, e)
+	case st.txRate == 0:
+		e := base
+		e.Type, e.Status = eventLineDown, statusResolved
+		e.Message = fmt.Sprintf("Line %s is up at %s down, %s up", id, formatRate(l.txRate), formatRate(l.rxRate))
+		events = append(events, e)
+	default:
+		e := base
+		e.Type, e.Status = eventResync, statusFiring
+		e.Message = fmt.Sprintf("Line %s resynced at %s down, %s up (was %s down)",
+			id, formatRate(l.txRate), formatRate(l.rxRate), formatRate(st.txRate))
+		events = append(events, e)
+	}
+	st.txRate = l.txRate
+
+	if monthly == 0 {
+		return events
+	}
+
+	// Quota going up within the same month means a top-up was bought; at the
+	// start of a month it's just the quota being reset. Quotas follow UK
+	// months, whatever the host's time zone.
+	month := now.In(chaos.Location()).Month()
+	if seen && remaining > st.remaining && month == st.month {
+		e := base
+		e.Type, e.Status = eventTopUp, statusFiring
+		e.Message = fmt.Sprintf("Line %s quota topped up by %s", id, formatBytes(remaining-st.remaining))
+		events = append(events, e)
+	}
+	st.remaining = remaining
+	st.month = month
+
+	pct := 100 * remaining / monthly
+	var breached float64
+	for _, t := range w.thresholds {
+		if pct <= t {
+			breached = t
+			break
+		}
+	}
+	// The lowest threshold breached is kept until the quota is above every
+	// threshold, so a quota hovering around one doesn't keep firing.
+	switch {
+	case breached != 0 && (st.breached == 0 || breached < st.breached):
+		e := base
+		e.Type, e.Status, e.ThresholdPercent = eventQuotaLow, statusFiring, breached
+		e.Message = fmt.Sprintf("Line %s has %.1f%% of its quota remaining (%s)", id, pct, formatBytes(remaining))
+		e.PredictedExhaustion = predictExhaustion(remaining, monthly, now)
+		events = append(events, e)
+		st.breached = breached
+	case breached == 0 && st.breached != 0:
+		e := base
+		e.Type, e.Status, e.ThresholdPercent = eventQuotaLow, statusResolved, st.breached
+		e.Message = fmt.Sprintf("Line %s quota is above %g%% again, with %.1f%% remaining", id, st.breached, pct)
+		events = append(events, e)
+		st.breached = 0
+	}
+	return events
+}
+
+func (w *watcher) send(events []event) {
+	for _, e := range events {
+		for _, n := range w.notifiers {
+			if err := n.notify(e); err != nil {
+				w.log.Error().Err(err).Str("notifier", n.name()).Str("event", e.Type).Msg("error sending notification")
+				continue
+			}
+			w.log.Info().Str("notifier", n.name()).Str("event", e.Type).Str("status", e.Status).Str("line_id", e.LineID).Msg("sent notification")
+		}
+	}
+}
+
+// formatRate formats a rate of n bits per second.
+func formatRate(n float64) string {
+	switch {
+	case n >= 1e9:
+		return fmt.Sprintf("%.1f Gb/s", n/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.1f Mb/s", n/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1f kb/s", n/1e3)
+	}
+	return fmt.Sprintf("%.0f b/s", n)
+}
+
+// formatBytes formats n bytes using decimal units, as used for quotas.
+func formatBytes(n float64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	for _, prefix := range "kMGTPE" {
+		n /= unit
+		if n < unit {
+			return fmt.Sprintf("%.1f %cB", n, prefix)
+		}
+	}
+	return fmt.Sprintf("%.1f EB", n)
+}
+
+// postJSON POSTs v as JSON to url, returning an error for non-2xx responses.
+func postJSON(client *http.Client, url string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("bad response code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// webhookNotifier POSTs events as JSON.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookNotifier(url string) *webhookNotifier {
+	return &webhookNotifier{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *webhookNotifier) name() string { return "webhook" }
+
+func (n *webhookNotifier) notify(e event) error {
+	return postJSON(n.client, n.url, e)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// recordingNotifier keeps the events it's sent.
+type recordingNotifier struct {
+	events []event
+}
+
+func (n *recordingNotifier) name() string { return "recording" }
+
+func (n *recordingNotifier) notify(e event) error {
+	n.events = append(n.events, e)
+	return nil
+}
+
+// lineSamples returns the samples pushed for a line with remaining of a
+// 100 GB quota and the given downstream sync rate.
+func lineSamples(id string, remaining, txRate float64, t time.Time) []sample {
+	labels := []label{{"line_id", id}}
+	return []sample{
+		{name: "aaisp_broadband_quota_remaining", labels: labels, value: remaining, time: t},
+		{name: "aaisp_broadband_quota_total", labels: labels, value: 100e9, time: t},
+		{name: "aaisp_broadband_tx_rate", labels: labels, value: txRate, time: t},
+		{name: "aaisp_broadband_rx_rate", labels: labels, value: txRate / 4, time: t},
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	// Mid-June, so consecutive samples are in the same UK month.
+	start := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+	type step struct {
+		remaining float64 // percentage of the quota
+		txRate    float64
+		after     time.Duration // since start
+	}
+	// got is an event's type, status and threshold.
+	type got struct {
+		typ, status string
+		threshold   float64
+	}
+	const rate = 80e6
+	tests := []struct {
+		name  string
+		steps []step
+		want  []got
+	}{
+		{
+			name:  "first sample below a threshold",
+			steps: []step{{4, rate, 0}},
+			want:  []got{{eventQuotaLow, statusFiring, 5}},
+		},
+		{
+			name:  "thresholds crossed in turn",
+			steps: []step{{30, rate, 0}, {19, rate, time.Hour}, {15, rate, 2 * time.Hour}, {9, rate, 3 * time.Hour}, {4, rate, 4 * time.Hour}},
+			want: []got{
+				{eventQuotaLow, statusFiring, 20},
+				{eventQuotaLow, statusFiring, 10},
+				{eventQuotaLow, statusFiring, 5},
+			},
+		},
+		{
+			name:  "several thresholds crossed at once",
+			steps: []step{{30, rate, 0}, {4, rate, time.Hour}},
+			want:  []got{{eventQuotaLow, statusFiring, 5}},
+		},
+		{
+			name:  "exactly on a threshold",
+			steps: []step{{30, rate, 0}, {20, rate, time.Hour}},
+			want:  []got{{eventQuotaLow, statusFiring, 20}},
+		},
+		{
+			// A small top-up takes the quota back over the threshold
+			// it just crossed, but crossing it again isn't notified.
+			name:  "hovering around a threshold",
+			steps: []step{{5.1, rate, 0}, {4.9, rate, time.Hour}, {5.1, rate, 2 * time.Hour}, {4.9, rate, 3 * time.Hour}},
+			want: []got{
+				{eventQuotaLow, statusFiring, 10},
+				{eventQuotaLow, statusFiring, 5},
+				{eventTopUp, statusFiring, 0},
+			},
+		},
+		{
+			name:  "top-up above every threshold",
+			steps: []step{{4, rate, 0}, {54, rate, time.Hour}},
+			want: []got{
+				{eventQuotaLow, statusFiring, 5},
+				{eventTopUp, statusFiring, 0},
+				{eventQuotaLow, statusResolved, 5},
+			},
+		},
+		{
+			name:  "top-up still below a threshold",
+			steps: []step{{4, rate, 0}, {8, rate, time.Hour}, {4, rate, 2 * time.Hour}},
+			want: []got{
+				{eventQuotaLow, statusFiring, 5},
+				{eventTopUp, statusFiring, 0},
+			},
+		},
+		{
+			name:  "monthly reset isn't a top-up",
+			steps: []step{{4, rate, 0}, {100, rate, 17 * 24 * time.Hour}},
+			want: []got{
+				{eventQuotaLow, statusFiring, 5},
+				{eventQuotaLow, statusResolved, 5},
+			},
+		},
+		{
+			name:  "resync",
+			steps: []step{{50, rate, 0}, {50, rate, time.Hour}, {50, 70e6, 2 * time.Hour}},
+			want:  []got{{eventResync, statusFiring, 0}},
+		},
+		{
+			name:  "line down and up",
+			steps: []step{{50, rate, 0}, {50, 0, time.Hour}, {50, 0, 2 * time.Hour}, {50, rate, 3 * time.Hour}},
+			want: []got{
+				{eventLineDown, statusFiring, 0},
+				{eventLineDown, statusResolved, 0},
+			},
+		},
+		{
+			name:  "first sample with the line down",
+			steps: []step{{50, 0, 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := new(recordingNotifier)
+			w, err := (&notifyConfig{thresholds: "20,10,5"}).watcher([]notifier{n}, zerolog.Nop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.steps {
+				if err := w.push(lineSamples("12345", s.remaining*1e9, s.txRate, start.Add(s.after))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var events []got
+			for _, e := range n.events {
+				if e.LineID != "12345" {
+					t.Errorf("event for line %q, want 12345", e.LineID)
+				}
+				events = append(events, got{e.Type, e.Status, e.ThresholdPercent})
+			}
+			if !reflect.DeepEqual(events, tt.want) {
+				t.Errorf("got events %v, want %v", events, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatcherLinesAreIndependent(t *testing.T) {
+	n := new(recordingNotifier)
+	w, err := (&notifyConfig{thresholds: "10"}).watcher([]notifier{n}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+	w.push(append(lineSamples("1", 50e9, 80e6, now), lineSamples("2", 5e9, 80e6, now)...))
+	if len(n.events) != 1 || n.events[0].LineID != "2" {
+		t.Fatalf("got events %+v, want quota_low for line 2", n.events)
+	}
+	w.push(append(lineSamples("1", 5e9, 80e6, now.Add(time.Hour)), lineSamples("2", 5e9, 80e6, now.Add(time.Hour))...))
+	if len(n.events) != 2 || n.events[1].LineID != "1" {
+		t.Errorf("got events %+v, want quota_low for line 1 only", n.events[1:])
+	}
+}
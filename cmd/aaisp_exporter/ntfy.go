@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ntfyNotifier publishes events to an ntfy topic, for push notifications to
+// phones.
+type ntfyNotifier struct {
+	url      string
+	token    string
+	username string
+	password string
+	client   *http.Client
+}
+
+func newNtfyNotifier(server, topic, token, username, password string) *ntfyNotifier {
+	return &ntfyNotifier{
+		url:      strings.TrimSuffix(server, "/") + "/" + topic,
+		token:    token,
+		username: username,
+		password: password,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (n *ntfyNotifier) name() string { return "ntfy" }
+
+func (n *ntfyNotifier) notify(e event) error {
+	msg := e.Message
+	if e.PredictedExhaustion != nil {
+		msg += "\nPredicted to run out " + e.PredictedExhaustion.Format("Mon 2 Jan 15:04")
+	}
+	req, err := http.NewRequest("POST", n.url, strings.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", e.title())
+	if e.Type == eventQuotaLow && e.Status == statusFiring {
+		req.Header.Set("Priority", "high")
+		req.Header.Set("Tags", "warning")
+	} else {
+		req.Header.Set("Tags", "white_check_mark")
+	}
+	switch {
+	case n.token != "":
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	case n.username != "":
+		req.SetBasicAuth(n.username, n.password)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("bad response code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+	"github.com/rs/zerolog"
+)
+
+// poller refreshes broadband line data in the background.
+//
+// The full line list is discovered infrequently, using BroadbandInfo, so newly
+// provisioned lines appear without a restart. Quotas change far more often and
+// are refreshed using the lighter BroadbandQuota call.
+//
+// If the API supports conditional requests, responses which haven't changed
+// since the last poll are skipped.
+type poller struct {
+	api               *chaos.API
+	discoveryInterval time.Duration
+	quotaInterval     time.Duration
+	log               zerolog.Logger
+
+	mu    sync.Mutex
+	lines []chaos.BroadbandInfo
+	err   error
+}
+
+// run polls the API until the process exits.
+func (p *poller) run() {
+	p.discover()
+	discovery := time.NewTicker(p.discoveryInterval)
+	defer discovery.Stop()
+	var quota <-chan time.Time
+	if p.quotaInterval > 0 {
+		t := time.NewTicker(p.quotaInterval)
+		defer t.Stop()
+		quota = t.C
+	}
+	for {
+		select {
+		case <-discovery.C:
+			p.discover()
+		case <-quota:
+			p.refreshQuota()
+		}
+	}
+}
+
+func (p *poller) discover() {
+	lines, err := p.api.BroadbandInfo()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if errors.Is(err, chaos.ErrNotModified) {
+		p.err = nil
+		p.log.Debug().Msg("broadband lines not modified")
+		return
+	}
+	p.err = err
+	var partial *chaos.PartialError
+	if errors.As(err, &partial) {
+		p.log.Warn().Err(err).Msg("some broadband lines couldn't be decoded")
+	} else if err != nil {
+		p.log.Error().Err(err).Msg("error discovering broadband lines")
+		return
+	}
+	p.lines = lines
+	p.log.Debug().Int("lines", len(lines)).Msg("discovered broadband lines")
+}
+
+func (p *poller) refreshQuota() {
+	quotas, err := p.api.BroadbandQuota()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if errors.Is(err, chaos.ErrNotModified) {
+		p.err = nil
+		p.log.Debug().Msg("broadband quota not modified")
+		return
+	}
+	p.err = err
+	var partial *chaos.PartialError
+	if errors.As(err, &partial) {
+		p.log.Warn().Err(err).Msg("some broadband quotas couldn't be decoded")
+	} else if err != nil {
+		p.log.Error().Err(err).Msg("error refreshing broadband quota")
+		return
+	}
+	for _, q := range quotas {
+		for i := range p.lines {
+			if p.lines[i].ID != q.ID {
+				continue
+			}
+			p.lines[i].QuotaMonthly = q.QuotaMonthly
+			p.lines[i].QuotaRemaining = q.QuotaRemaining
+			p.lines[i].QuotaTimestamp = q.QuotaTimestamp
+		}
+	}
+}
+
+// BroadbandInfo returns the most recently polled line information. The error
+// from the most recent poll is returned so failures are still visible to the
+// collector. If the poll only partly failed, the lines are returned too.
+func (p *poller) BroadbandInfo() ([]chaos.BroadbandInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var partial *chaos.PartialError
+	if p.err != nil && !errors.As(p.err, &partial) {
+		return nil, p.err
+	}
+	if p.lines == nil {
+		return nil, errors.New("no data polled yet")
+	}
+	lines := make([]chaos.BroadbandInfo, len(p.lines))
+	copy(lines, p.lines)
+	return lines, p.err
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket for a single client.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// ipLimiter limits the request rate from each client address using a token
+// bucket per address.
+type ipLimiter struct {
+	rate  float64 // tokens added per second
+	burst float64
+	clock clock
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func newIPLimiter(rate float64, burst int) *ipLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	l := &ipLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		clock:   realClock{},
+		buckets: make(map[string]*bucket),
+	}
+	go l.cleanup(time.Minute)
+	return l
+}
+
+func (l *ipLimiter) allow(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// cleanup periodically forgets clients whose buckets have refilled, so the
+// map doesn't grow without bound.
+func (l *ipLimiter) cleanup(interval time.Duration) {
+	for range time.Tick(interval) {
+		l.evict()
+	}
+}
+
+// evict forgets clients whose buckets have refilled.
+func (l *ipLimiter) evict() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	for ip, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+func (l *ipLimiter) middleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if !l.allow(ip) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
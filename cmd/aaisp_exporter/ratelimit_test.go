@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock which only moves when told to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTestLimiter(rate float64, burst int) (*ipLimiter, *fakeClock) {
+	clk := &fakeClock{now: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)}
+	return &ipLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		clock:   clk,
+		buckets: make(map[string]*bucket),
+	}, clk
+}
+
+func TestIPLimiterBurst(t *testing.T) {
+	l, _ := newTestLimiter(1, 3)
+	for i := 0; i < 3; i++ {
+		if !l.allow("192.0.2.1") {
+			t.Fatalf("request %d refused within the burst", i+1)
+		}
+	}
+	if l.allow("192.0.2.1") {
+		t.Error("request allowed after the burst was used up")
+	}
+}
+
+func TestIPLimiterRefill(t *testing.T) {
+	l, clk := newTestLimiter(2, 2)
+	l.allow("192.0.2.1")
+	l.allow("192.0.2.1")
+	if l.allow("192.0.2.1") {
+		t.Fatal("request allowed with an empty bucket")
+	}
+
+	// At 2 tokens a second, a token takes half a second.
+	clk.advance(400 * time.Millisecond)
+	if l.allow("192.0.2.1") {
+		t.Error("request allowed before a token was added")
+	}
+	clk.advance(100 * time.Millisecond)
+	if !l.allow("192.0.2.1") {
+		t.Error("request refused after a token was added")
+	}
+
+	// The bucket never holds more than the burst.
+	clk.advance(time.Hour)
+	for i := 0; i < 2; i++ {
+		if !l.allow("192.0.2.1") {
+			t.Fatalf("request %d refused after refilling", i+1)
+		}
+	}
+	if l.allow("192.0.2.1") {
+		t.Error("bucket refilled beyond the burst")
+	}
+}
+
+func TestIPLimiterPerIP(t *testing.T) {
+	l, _ := newTestLimiter(1, 1)
+	if !l.allow("192.0.2.1") {
+		t.Fatal("first request refused")
+	}
+	if l.allow("192.0.2.1") {
+		t.Error("second request from the same address allowed")
+	}
+	if !l.allow("192.0.2.2") {
+		t.Error("another address was limited by the first's requests")
+	}
+}
+
+func TestIPLimiterEvict(t *testing.T) {
+	l, clk := newTestLimiter(1, 5)
+	for i := 0; i < 5; i++ {
+		l.allow("192.0.2.1")
+	}
+	clk.advance(3 * time.Second)
+	l.allow("192.0.2.2")
+
+	// 192.0.2.1 has 3 of 5 tokens and 192.0.2.2 has 4, so neither is full.
+	l.evict()
+	if len(l.buckets) != 2 {
+		t.Fatalf("evicted clients whose buckets weren't full: %d left, want 2", len(l.buckets))
+	}
+
+	clk.advance(1500 * time.Millisecond)
+	l.evict()
+	if _, ok := l.buckets["192.0.2.1"]; !ok {
+		t.Error("evicted 192.0.2.1 before its bucket refilled")
+	}
+	if _, ok := l.buckets["192.0.2.2"]; ok {
+		t.Error("didn't evict 192.0.2.2 once its bucket refilled")
+	}
+
+	clk.advance(time.Second)
+	l.evict()
+	if len(l.buckets) != 0 {
+		t.Errorf("%d clients left after every bucket refilled, want 0", len(l.buckets))
+	}
+}
+
+func TestIPLimiterMiddleware(t *testing.T) {
+	l, _ := newTestLimiter(1, 1)
+	h := l.middleware(okHandler)
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r.RemoteAddr = "192.0.2.1:5000"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("request %d: got status %d, want %d", i+1, w.Code, want)
+		}
+	}
+	// A different port from the same address shares the bucket.
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.RemoteAddr = "192.0.2.1:5001"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("new connection from a limited address: got status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+}
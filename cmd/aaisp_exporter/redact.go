@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+	"github.com/rs/zerolog"
+)
+
+// redactWriter removes credentials from log messages before writing them to
+// w, so they can't be logged at any level, whether by the exporter or in an
+// error from a library.
+type redactWriter struct {
+	w io.Writer
+}
+
+func (r *redactWriter) redact(p []byte) []byte {
+	return []byte(chaos.Redact(string(p)))
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	if _, err := r.w.Write(r.redact(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (r *redactWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	lw, ok := r.w.(zerolog.LevelWriter)
+	if !ok {
+		return r.Write(p)
+	}
+	if _, err := lw.WriteLevel(level, r.redact(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
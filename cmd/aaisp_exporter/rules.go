@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// ruleConfig holds the thresholds used when generating alerting rules.
+type ruleConfig struct {
+	quotaLow  float64       // percent of monthly quota remaining
+	duration  time.Duration // how long a condition must hold before firing
+	stale     time.Duration // maximum age of cached data
+	cache     bool          // whether the cache metrics are exposed
+	quotaOnly bool          // whether only the quota metrics are exposed
+}
+
+type alertRule struct {
+	Alert       string
+	Expr        string
+	For         string
+	Severity    string
+	Summary     string
+	Description string
+}
+
+var rulesTemplate = template.Must(template.New("rules").Funcs(template.FuncMap{
+	"quote": strconv.Quote,
+}).Parse(`groups:
+  - name: aaisp
+    rules:
+{{- range .}}
+      - alert: {{.Alert}}
+        expr: {{quote .Expr}}
+{{- if .For}}
+        for: {{.For}}
+{{- end}}
+        labels:
+          severity: {{.Severity}}
+        annotations:
+          summary: {{quote .Summary}}
+          description: {{quote .Description}}
+{{- end}}
+`))
+
+// generateRules writes a Prometheus rules file alerting on the metrics the
+// exporter would expose with the given filters.
+func generateRules(w io.Writer, g filterGatherer, cfg ruleConfig) error {
+	var (
+		remaining   = broadbandQuotaRemaining.name
+		total       = broadbandQuotaTotal.name
+		txRate      = broadbandTXRate.name
+		success     = scrapeSuccess.name
+		forDuration = model.Duration(cfg.duration).String()
+	)
+	id, line, byLine := "", "", ""
+	if !g.dropLabels["line_id"] {
+		id = " {{ $labels.line_id }}"
+		line = " on line" + id
+		byLine = " by (line_id)"
+	}
+
+	var rules []alertRule
+	if cfg.quotaLow > 0 && g.exposes(remaining) && g.exposes(total) {
+		rules = append(rules, alertRule{
+			Alert:       "AAISPQuotaLow",
+			Expr:        fmt.Sprintf("%s / %s * 100 < %s and %s > 0", remaining, total, formatFloat(cfg.quotaLow), total),
+			Severity:    "warning",
+			Summary:     "AAISP quota low" + line,
+			Description: "Less than " + formatFloat(cfg.quotaLow) + "% of the monthly quota remains" + line + ".",
+		})
+	}
+	if g.exposes(txRate) && !cfg.quotaOnly {
+		rules = append(rules, alertRule{
+			Alert:       "AAISPLineDown",
+			Expr:        fmt.Sprintf("max%s (%s) == 0", byLine, txRate),
+			For:         forDuration,
+			Severity:    "critical",
+			Summary:     "AAISP line" + id + " down",
+			Description: "The line has reported no sync rate for " + forDuration + ".",
+		})
+	}
+	if cfg.cache && g.exposes(cacheAgeName) {
+		rules = append(rules, alertRule{
+			Alert:       "AAISPDataStale",
+			Expr:        fmt.Sprintf("%s > %s", cacheAgeName, formatFloat(cfg.stale.Seconds())),
+			For:         forDuration,
+			Severity:    "warning",
+			Summary:     "AAISP data is stale",
+			Description: "The exporter's cached line data is older than " + model.Duration(cfg.stale).String() + ".",
+		})
+	} else if g.exposes(success) {
+		rules = append(rules, alertRule{
+			Alert:       "AAISPDataStale",
+			Expr:        fmt.Sprintf("absent_over_time(%s[%s])", success, forDuration),
+			Severity:    "warning",
+			Summary:     "AAISP data is stale",
+			Description: "The exporter hasn't been scraped for " + forDuration + ".",
+		})
+	}
+	if g.exposes(success) {
+		rules = append(rules, alertRule{
+			Alert:       "AAISPScrapeFailing",
+			Expr:        fmt.Sprintf("%s == 0", success),
+			For:         forDuration,
+			Severity:    "warning",
+			Summary:     "AAISP API scrapes failing",
+			Description: "The exporter has been unable to query the CHAOS API for " + forDuration + ".",
+		})
+	}
+	if len(rules) == 0 {
+		return fmt.Errorf("no metrics are exposed with the current filters")
+	}
+	return rulesTemplate.Execute(w, rules)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
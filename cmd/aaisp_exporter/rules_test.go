@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestRulesUseExposedMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := ruleConfig{quotaLow: 10, duration: 15 * time.Minute, stale: time.Hour}
+	if err := generateRules(&buf, filterGatherer{}, cfg); err != nil {
+		t.Fatal(err)
+	}
+	exposed := make(map[string]bool)
+	for _, name := range exposedNames(t) {
+		exposed[name] = true
+	}
+	used := regexp.MustCompile(`aaisp_[a-z_]+`).FindAllString(buf.String(), -1)
+	if len(used) == 0 {
+		t.Fatal("rules don't use any metrics")
+	}
+	for _, name := range used {
+		if !exposed[name] {
+			t.Errorf("rules use %s, which isn't exposed", name)
+		}
+	}
+	for _, name := range []string{broadbandQuotaRemaining.name, broadbandQuotaTotal.name, broadbandTXRate.name, scrapeSuccess.name} {
+		if !bytes.Contains(buf.Bytes(), []byte(name)) {
+			t.Errorf("rules don't use %s", name)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+
+	"github.com/rs/zerolog"
+)
+
+var errNoService = errors.New("running as a service is only supported on Windows; use your init system instead")
+
+func isService() bool { return false }
+
+func controlService(action string, args []string) error { return errNoService }
+
+func newServiceLogWriter() (zerolog.LevelWriter, error) { return nil, errNoService }
+
+func runService(serve func() error) error { return errNoService }
@@ -0,0 +1,220 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const serviceName = "aaisp_exporter"
+
+// isService reports whether the exporter was started by the service control
+// manager.
+func isService() bool {
+	ok, err := svc.IsWindowsService()
+	return err == nil && ok
+}
+
+// controlService installs or uninstalls the Windows service. When installing,
+// args are the flags the service is started with.
+func controlService(action string, args []string) error {
+	switch action {
+	case "install":
+		return installService(args)
+	case "uninstall":
+		return uninstallService()
+	}
+	return fmt.Errorf("unknown service action %q (install, uninstall)", action)
+}
+
+// serviceEnv lists, for each credentials flag, the environment variables it
+// needs which aren't secret, and so are copied to the service's environment.
+var serviceEnv = map[string][]string{
+	"chaos.credentials-file": {"CHAOS_AGE_IDENTITY_FILE"},
+	"aws.secret":             {"AWS_REGION", "AWS_DEFAULT_REGION"},
+	"gcp.secret":             {"GCE_METADATA_HOST"},
+}
+
+// installService installs the service, started with args. The service's
+// configuration, including its environment, is in the registry where every
+// local user can read it, so credentials are never stored there. Instead the
+// service reads them from an age-encrypted file, whose identity file can be
+// protected with file permissions, or from a secret store using the
+// instance's cloud role.
+func installService(args []string) error {
+	var env []string
+	for name, vars := range serviceEnv {
+		if !hasFlag(args, name) {
+			continue
+		}
+		for _, v := range vars {
+			if value := os.Getenv(v); value != "" {
+				env = append(env, v+"="+value)
+			}
+		}
+	}
+	switch {
+	case hasFlag(args, "vault.path"):
+		return errors.New("-vault.path needs a Vault token or AppRole secret ID, which can't be given to the service securely; use -chaos.credentials-file instead")
+	case hasFlag(args, "chaos.credentials-file") && os.Getenv("CHAOS_AGE_IDENTITY_FILE") == "":
+		return errors.New("CHAOS_AGE_IDENTITY_FILE must be set to install the service with -chaos.credentials-file; a passphrase can't be given to the service securely")
+	case !hasFlag(args, "chaos.credentials-file") && !hasFlag(args, "aws.secret") && !hasFlag(args, "gcp.secret"):
+		return errors.New("the service needs -chaos.credentials-file, -aws.secret or -gcp.secret; credentials in the environment aren't stored in the service's configuration, which every local user can read")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", serviceName)
+	}
+
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "AAISP exporter",
+		Description: "Prometheus exporter for Andrews & Arnold broadband lines",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	// Restart after a minute if the exporter exits unexpectedly.
+	s.SetRecoveryActions([]mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: time.Minute}}, 24*60*60)
+
+	// Services don't inherit the installing user's environment, so the
+	// settings the credentials flags need are copied to the service's own
+	// environment. None of them are secret.
+	if env != nil {
+		k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\`+serviceName, registry.SET_VALUE)
+		if err != nil {
+			s.Delete()
+			return err
+		}
+		defer k.Close()
+		if err := k.SetStringsValue("Environment", env); err != nil {
+			s.Delete()
+			return err
+		}
+	}
+
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("installing event log source: %w", err)
+	}
+	return nil
+}
+
+// hasFlag reports whether the flag called name is given in args, in either
+// the "-name value" or "-name=value" form.
+func hasFlag(args []string, name string) bool {
+	for _, arg := range args {
+		a := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if a == name || strings.HasPrefix(a, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s isn't installed", serviceName)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	return eventlog.Remove(serviceName)
+}
+
+// eventLogWriter writes log messages to the Windows event log, at the event
+// type matching their level.
+type eventLogWriter struct {
+	log *eventlog.Log
+}
+
+// newServiceLogWriter returns a writer for the service's log messages.
+func newServiceLogWriter() (zerolog.LevelWriter, error) {
+	l, err := eventlog.Open(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return eventLogWriter{l}, nil
+}
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.InfoLevel, p)
+}
+
+func (w eventLogWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+	var err error
+	switch {
+	case level >= zerolog.ErrorLevel:
+		err = w.log.Error(1, msg)
+	case level == zerolog.WarnLevel:
+		err = w.log.Warning(1, msg)
+	default:
+		err = w.log.Info(1, msg)
+	}
+	return len(p), err
+}
+
+// service runs the exporter under the service control manager.
+type service struct {
+	serve func() error
+	err   error
+}
+
+// runService runs serve as a Windows service until it fails or the service
+// is stopped.
+func runService(serve func() error) error {
+	s := &service{serve: serve}
+	if err := svc.Run(serviceName, s); err != nil {
+		return err
+	}
+	return s.err
+}
+
+func (s *service) Execute(_ []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+	errc := make(chan error, 1)
+	go func() { errc <- s.serve() }()
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case s.err = <-errc:
+			return true, 1
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				return false, 0
+			}
+		}
+	}
+}
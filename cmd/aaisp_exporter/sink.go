@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+)
+
+// label is a metric label.
+type label struct {
+	name, value string
+}
+
+// sample is a single metric value, flattened from a MetricFamily for sinks
+// which don't use the Prometheus data model.
+type sample struct {
+	name   string
+	help   string
+	labels []label
+	value  float64
+	time   time.Time
+}
+
+// labelValue returns the value of the named label, or "" if it isn't set.
+func (s sample) labelValue(name string) string {
+	for _, l := range s.labels {
+		if l.name == name {
+			return l.value
+		}
+	}
+	return ""
+}
+
+// samples flattens gathered metric families into samples. Only counters,
+// gauges and untyped metrics are included; the exporter doesn't produce
+// histograms or summaries. Info metrics are left out too, since they only
+// carry labels for joining in PromQL, which the sinks can't do.
+func samples(mfs []*dto.MetricFamily, now time.Time) []sample {
+	var ss []sample
+	for _, mf := range mfs {
+		if strings.HasSuffix(mf.GetName(), "_info") {
+			continue
+		}
+		for _, m := range mf.Metric {
+			var v float64
+			switch {
+			case m.Gauge != nil:
+				v = m.Gauge.GetValue()
+			case m.Counter != nil:
+				v = m.Counter.GetValue()
+			case m.Untyped != nil:
+				v = m.Untyped.GetValue()
+			default:
+				continue
+			}
+			s := sample{name: mf.GetName(), help: mf.GetHelp(), value: v, time: now}
+			for _, l := range m.Label {
+				s.labels = append(s.labels, label{l.GetName(), l.GetValue()})
+			}
+			ss = append(ss, s)
+		}
+	}
+	return ss
+}
+
+// sink pushes metrics to a system other than Prometheus.
+type sink interface {
+	name() string
+	push(samples []sample) error
+}
+
+// sinkConfig is the configuration for a sink, set by flags.
+type sinkConfig interface {
+	// sink returns the configured sink, or nil if it isn't enabled.
+	sink() (sink, error)
+}
+
+// runSinks gathers metrics from g every interval and pushes them to each
+// sink, timestamped by clk. The metrics pushed to sinks go through filter
+// first, as they would when scraped; those pushed to unfiltered, such as the
+// notification watcher, don't, so dropping metrics or labels from the
+// exposition can't break them.
+func runSinks(sinks, unfiltered []sink, g prometheus.Gatherer, filter filterGatherer, interval time.Duration, clk clock, log zerolog.Logger) {
+	pushTo := func(sinks []sink, ss []sample) {
+		for _, s := range sinks {
+			if err := s.push(ss); err != nil {
+				log.Error().Err(err).Str("sink", s.name()).Msg("error pushing metrics")
+				continue
+			}
+			log.Debug().Str("sink", s.name()).Int("samples", len(ss)).Msg("pushed metrics")
+		}
+	}
+	push := func() {
+		mfs, err := g.Gather()
+		if err != nil {
+			log.Error().Err(err).Msg("error gathering metrics for sinks")
+		}
+		now := clk.Now()
+		// The filter modifies the metric families, so the unfiltered samples
+		// are taken first.
+		if len(unfiltered) > 0 {
+			pushTo(unfiltered, samples(mfs, now))
+		}
+		if len(sinks) > 0 {
+			pushTo(sinks, samples(filter.filter(mfs), now))
+		}
+	}
+	push()
+	for range time.Tick(interval) {
+		push()
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// slackNotifier sends events to a Slack incoming webhook.
+type slackNotifier struct {
+	url     string
+	channel string
+	client  *http.Client
+}
+
+func newSlackNotifier(url, channel string) *slackNotifier {
+	return &slackNotifier{url: url, channel: channel, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *slackNotifier) name() string { return "slack" }
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackBlock struct {
+	Type   string      `json:"type"`
+	Text   *slackText  `json:"text,omitempty"`
+	Fields []slackText `json:"fields,omitempty"`
+}
+
+type slackMessage struct {
+	Channel string       `json:"channel,omitempty"`
+	Text    string       `json:"text"`
+	Blocks  []slackBlock `json:"blocks"`
+}
+
+func (n *slackNotifier) notify(e event) error {
+	emoji := ":warning:"
+	if e.Status == statusResolved || e.Type == eventTopUp {
+		emoji = ":white_check_mark:"
+	}
+	field := func(name, value string) slackText {
+		return slackText{Type: "mrkdwn", Text: fmt.Sprintf("*%s*\n%s", name, value)}
+	}
+	fields := []slackText{
+		field("Line", e.LineID),
+		field("Quota remaining", fmt.Sprintf("%s of %s", formatBytes(e.QuotaRemaining), formatBytes(e.QuotaMonthly))),
+	}
+	if e.PredictedExhaustion != nil {
+		fields = append(fields, field("Predicted exhaustion", e.PredictedExhaustion.Format("Mon 2 Jan 15:04")))
+	}
+
+	msg := slackMessage{
+		Channel: n.channel,
+		Text:    e.Message,
+		Blocks: []slackBlock{
+			{Type: "section", Text: &slackText{Type: "mrkdwn", Text: fmt.Sprintf("%s *%s*\n%s", emoji, e.title(), e.Message)}},
+			{Type: "section", Fields: fields},
+		},
+	}
+	return postJSON(n.client, n.url, msg)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/jamesog/aaisp-chaos/sms"
+)
+
+// smsNotifier sends events as text messages through AAISP's SMS gateway,
+// using the same credentials as the CHAOS API. Each message costs money, so
+// only selected event types are sent.
+type smsNotifier struct {
+	client *sms.Client
+	to     []string
+	from   string
+	events map[string]bool
+}
+
+func newSMSNotifier(client *sms.Client, to []string, from, events string) *smsNotifier {
+	n := &smsNotifier{client: client, to: to, from: from, events: make(map[string]bool)}
+	for _, e := range strings.Split(events, ",") {
+		n.events[strings.TrimSpace(e)] = true
+	}
+	return n
+}
+
+func (n *smsNotifier) name() string { return "sms" }
+
+func (n *smsNotifier) notify(e event) error {
+	if !n.events[e.Type] {
+		return nil
+	}
+	text := "AAISP: " + e.Message
+	if e.PredictedExhaustion != nil {
+		text += ". Runs out " + e.PredictedExhaustion.Format("Mon 2 Jan 15:04")
+	}
+	_, err := n.client.Send(sms.Message{To: n.to, From: n.from, Text: text})
+	return err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statsdMaxPacket is the largest UDP payload sent, chosen to fit within a
+// typical Ethernet MTU.
+const statsdMaxPacket = 1432
+
+// statsdConfig configures the StatsD sink.
+type statsdConfig struct {
+	address   string
+	prefix    string
+	dogstatsd bool
+}
+
+func addStatsDFlags(fs *flag.FlagSet) *statsdConfig {
+	c := new(statsdConfig)
+	fs.StringVar(&c.address, "statsd.address", "", "send metrics as gauges to the StatsD server at `host:port`")
+	fs.StringVar(&c.prefix, "statsd.prefix", "aaisp", "`prefix` for StatsD metric names")
+	fs.BoolVar(&c.dogstatsd, "statsd.dogstatsd", false, "send labels as DogStatsD tags rather than in the metric name")
+	return c
+}
+
+func (c *statsdConfig) sink() (sink, error) {
+	if c.address == "" {
+		return nil, nil
+	}
+	return &statsdSink{config: *c}, nil
+}
+
+// statsdSink sends metrics as StatsD gauges. Metric names are built in the same
+// way as for Graphite, e.g. "aaisp.broadband_quota_remaining.12345", or with
+// DogStatsD the labels are sent as tags instead.
+type statsdSink struct {
+	config statsdConfig
+}
+
+func (s *statsdSink) name() string { return "statsd" }
+
+func (s *statsdSink) line(sm sample) string {
+	parts := []string{graphiteSanitize(strings.TrimPrefix(sm.name, "aaisp_"))}
+	if s.config.prefix != "" {
+		parts = append([]string{s.config.prefix}, parts...)
+	}
+	if !s.config.dogstatsd {
+		for _, l := range sm.labels {
+			parts = append(parts, graphiteSanitize(l.value))
+		}
+	}
+	line := statsdSanitize(strings.Join(parts, ".")) + ":" + strconv.FormatFloat(sm.value, 'f', -1, 64) + "|g"
+	if s.config.dogstatsd && len(sm.labels) > 0 {
+		tags := make([]string, len(sm.labels))
+		for i, l := range sm.labels {
+			tags[i] = l.name + ":" + statsdSanitize(l.value)
+		}
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+// statsdSanitize replaces characters which separate the fields of a StatsD
+// line, DogStatsD tags and lines in a packet.
+func statsdSanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', ',', '#', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+func (s *statsdSink) push(samples []sample) error {
+	conn, err := net.DialTimeout("udp", s.config.address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var buf bytes.Buffer
+	flush := func() error {
+		if buf.Len() == 0 {
+			return nil
+		}
+		_, err := conn.Write(buf.Bytes())
+		buf.Reset()
+		return err
+	}
+	for _, sm := range samples {
+		line := s.line(sm)
+		if buf.Len() > 0 && buf.Len()+1+len(line) > statsdMaxPacket {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	return flush()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStatsDWireFormat(t *testing.T) {
+	labels := []label{{"line_id", "12345"}, {"login", "a,b|c:d#e@a.1"}}
+	samples := []sample{
+		{name: "aaisp_broadband_quota_remaining", labels: labels[:1], value: 150e9},
+		{name: "aaisp_broadband_line_info", labels: labels, value: 1},
+		{name: "aaisp_scrape_success", value: 1},
+	}
+	tests := []struct {
+		name      string
+		dogstatsd bool
+		want      string
+	}{
+		{"statsd", false, "aaisp.broadband_quota_remaining.12345:150000000000|g\n" +
+			"aaisp.broadband_line_info.12345.a_b_c_d_e@a_1:1|g\n" +
+			"aaisp.scrape_success:1|g"},
+		{"dogstatsd", true, "aaisp.broadband_quota_remaining:150000000000|g|#line_id:12345\n" +
+			"aaisp.broadband_line_info:1|g|#line_id:12345,login:a_b_c_d_e@a.1\n" +
+			"aaisp.scrape_success:1|g"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			s := &statsdSink{config: statsdConfig{address: conn.LocalAddr().String(), prefix: "aaisp", dogstatsd: tt.dogstatsd}}
+			if err := s.push(samples); err != nil {
+				t.Fatal(err)
+			}
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			buf := make([]byte, statsdMaxPacket)
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(buf[:n]); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// telegramBot sends notifications to Telegram chats and answers commands from
+// those chats.
+type telegramBot struct {
+	apiURL string
+	token  string
+	chats  map[int64]bool
+	lines  lineSource
+	log    zerolog.Logger
+	client *http.Client
+}
+
+func newTelegramBot(apiURL, token string, chats []int64, lines lineSource, log zerolog.Logger) *telegramBot {
+	b := &telegramBot{
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		token:  token,
+		chats:  make(map[int64]bool),
+		lines:  lines,
+		log:    log,
+		// Long enough for getUpdates long polling.
+		client: &http.Client{Timeout: 60 * time.Second},
+	}
+	for _, id := range chats {
+		b.chats[id] = true
+	}
+	return b
+}
+
+func (b *telegramBot) name() string { return "telegram" }
+
+func (b *telegramBot) notify(e event) error {
+	text := fmt.Sprintf("%s\n%s", e.title(), e.Message)
+	if e.PredictedExhaustion != nil {
+		text += "\nPredicted to run out " + e.PredictedExhaustion.Format("Mon 2 Jan 15:04")
+	}
+	var errs []string
+	for id := range b.chats {
+		if err := b.send(id, text); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// call calls a Bot API method and decodes the result into v.
+func (b *telegramBot) call(method string, params url.Values, v interface{}) error {
+	resp, err := b.client.PostForm(b.apiURL+"/bot"+b.token+"/"+method, params)
+	if err != nil {
+		// The error includes the URL, which contains the token.
+		return fmt.Errorf("telegram %s: %s", method, strings.Replace(err.Error(), b.token, "<token>", -1))
+	}
+	defer resp.Body.Close()
+	r := struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return fmt.Errorf("telegram %s: %w", method, err)
+	}
+	if !r.OK {
+		return fmt.Errorf("telegram %s: %s", method, r.Description)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(r.Result, v)
+}
+
+func (b *telegramBot) send(chat int64, text string) error {
+	return b.call("sendMessage", url.Values{
+		"chat_id": {strconv.FormatInt(chat, 10)},
+		"text":    {text},
+	}, nil)
+}
+
+type telegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+		Text string `json:"text"`
+	} `json:"message"`
+}
+
+// run long polls for messages and answers commands until the process exits.
+func (b *telegramBot) run() {
+	var offset int64
+	for {
+		var updates []telegramUpdate
+		err := b.call("getUpdates", url.Values{
+			"offset":          {strconv.FormatInt(offset, 10)},
+			"timeout":         {"50"},
+			"allowed_updates": {`["message"]`},
+		}, &updates)
+		if err != nil {
+			b.log.Error().Err(err).Msg("error getting Telegram updates")
+			time.Sleep(30 * time.Second)
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message == nil {
+				continue
+			}
+			chat := u.Message.Chat.ID
+			if !b.chats[chat] {
+				b.log.Warn().Int64("chat_id", chat).Msg("ignoring Telegram message from unauthorised chat")
+				continue
+			}
+			if err := b.send(chat, b.reply(u.Message.Text)); err != nil {
+				b.log.Error().Err(err).Msg("error replying to Telegram command")
+			}
+		}
+	}
+}
+
+// reply returns the response to a command.
+func (b *telegramBot) reply(text string) string {
+	// Commands in groups may be addressed as /command@botname.
+	cmd := strings.SplitN(strings.Fields(text + " ")[0], "@", 2)[0]
+	switch cmd {
+	case "/quota", "/status":
+	default:
+		return "Commands:\n/quota - remaining quota per line\n/status - line rates and quota"
+	}
+
+	lines, err := b.lines.BroadbandInfo()
+	if err != nil {
+		return "Error getting line information: " + err.Error()
+	}
+	if len(lines) == 0 {
+		return "No lines found."
+	}
+	var s strings.Builder
+	for _, l := range lines {
+		fmt.Fprintf(&s, "Line %d (%s)\n", l.ID, l.Login)
+		if l.QuotaMonthly > 0 {
+			fmt.Fprintf(&s, "  Quota: %s of %s remaining (%.1f%%)\n",
+				formatBytes(float64(l.QuotaRemaining)), formatBytes(float64(l.QuotaMonthly)),
+				100*float64(l.QuotaRemaining)/float64(l.QuotaMonthly))
+		}
+		if cmd == "/status" {
+			fmt.Fprintf(&s, "  Rates: %.1f Mb/s down, %.1f Mb/s up\n", float64(l.TXRate)/1e6, float64(l.RXRate)/1e6)
+		}
+	}
+	return s.String()
+}
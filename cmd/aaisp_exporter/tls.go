@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// certReloader serves a TLS certificate from files, loading it again when the
+// files change or the exporter receives SIGHUP, so renewed certificates are
+// used without a restart.
+type certReloader struct {
+	certFile, keyFile string
+	log               zerolog.Logger
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string, log zerolog.Logger) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, log: log}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load reads the certificate and key. If they can't be read, such as when
+// only one of them has been replaced so far, the previous certificate is kept.
+func (r *certReloader) load() error {
+	modTime := r.latestModTime()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.cert, r.modTime = &cert, modTime
+	r.mu.Unlock()
+	return nil
+}
+
+// latestModTime returns the later of the files' modification times.
+func (r *certReloader) latestModTime() time.Time {
+	var t time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		if fi, err := os.Stat(f); err == nil && fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t
+}
+
+// watch reloads the certificate when the files' modification times change,
+// checking every interval, or when SIGHUP is received.
+func (r *certReloader) watch(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-hup:
+		case <-tick.C:
+			r.mu.RLock()
+			unchanged := r.latestModTime().Equal(r.modTime)
+			r.mu.RUnlock()
+			if unchanged {
+				continue
+			}
+		}
+		if err := r.load(); err != nil {
+			r.log.Error().Err(err).Msg("couldn't reload TLS certificate; keeping the previous one")
+			continue
+		}
+		r.log.Info().Str("cert", r.certFile).Msg("reloaded TLS certificate")
+	}
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
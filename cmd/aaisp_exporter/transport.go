@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// transportMetrics records how long each stage of an API request takes, to
+// tell a slow API apart from local network problems such as slow DNS.
+type transportMetrics struct {
+	dns       prometheus.Histogram
+	connect   prometheus.Histogram
+	tls       prometheus.Histogram
+	firstByte prometheus.Histogram
+	open      prometheus.Gauge
+	conns     *prometheus.CounterVec
+	next      http.RoundTripper
+}
+
+func newTransportMetrics() *transportMetrics {
+	histogram := func(name, help string) prometheus.Histogram {
+		return prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "aaisp_exporter_api_" + name + "_duration_seconds",
+			Help:    help,
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+		})
+	}
+	return &transportMetrics{
+		dns:       histogram("dns", "Time taken to resolve the API's address"),
+		connect:   histogram("connect", "Time taken to open a TCP connection to the API"),
+		tls:       histogram("tls", "Time taken for the TLS handshake with the API"),
+		firstByte: histogram("first_byte", "Time from sending an API request to receiving the first byte of the response"),
+		open: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "aaisp_exporter_api_connections_open",
+			Help: "Number of open connections to the API",
+		}),
+		conns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "aaisp_exporter_api_connections_total",
+			Help: "Number of connections used for API requests, by whether they were reused",
+		}, []string{"reused"}),
+	}
+}
+
+// register registers the transport's metrics.
+func (m *transportMetrics) register(reg prometheus.Registerer) {
+	reg.MustRegister(m.dns, m.connect, m.tls, m.firstByte, m.open, m.conns)
+}
+
+// instrument returns a RoundTripper which records metrics for requests made
+// with t.
+func (m *transportMetrics) instrument(t *http.Transport) http.RoundTripper {
+	dial := t.DialContext
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		m.open.Inc()
+		return &countedConn{Conn: conn, closed: m.open.Dec}, nil
+	}
+	m.next = t
+	return m
+}
+
+// RoundTrip implements http.RoundTripper.
+func (m *transportMetrics) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), m.trace()))
+	return m.next.RoundTrip(req)
+}
+
+// trace returns hooks which record the timings of a single request. With
+// Happy Eyeballs, connections to several addresses are made at once, so the
+// hooks can be called concurrently and connections are timed by address.
+func (m *transportMetrics) trace() *httptrace.ClientTrace {
+	var (
+		mu                 sync.Mutex
+		dnsStart, tlsStart time.Time
+		wrote              time.Time
+		connectStart       = make(map[string]time.Time)
+	)
+	since := func(start *time.Time, h prometheus.Histogram) {
+		mu.Lock()
+		t := *start
+		mu.Unlock()
+		if !t.IsZero() {
+			h.Observe(time.Since(t).Seconds())
+		}
+	}
+	set := func(t *time.Time) {
+		mu.Lock()
+		*t = time.Now()
+		mu.Unlock()
+	}
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { set(&dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { since(&dnsStart, m.dns) },
+		ConnectStart: func(network, addr string) {
+			mu.Lock()
+			connectStart[network+" "+addr] = time.Now()
+			mu.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			mu.Lock()
+			start, ok := connectStart[network+" "+addr]
+			delete(connectStart, network+" "+addr)
+			mu.Unlock()
+			if ok && err == nil {
+				m.connect.Observe(time.Since(start).Seconds())
+			}
+		},
+		TLSHandshakeStart: func() { set(&tlsStart) },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				since(&tlsStart, m.tls)
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			m.conns.WithLabelValues(strconv.FormatBool(info.Reused)).Inc()
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { set(&wrote) },
+		GotFirstResponseByte: func() { since(&wrote, m.firstByte) },
+	}
+}
+
+// countedConn calls closed the first time it's closed.
+type countedConn struct {
+	net.Conn
+	once   sync.Once
+	closed func()
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(c.closed)
+	return c.Conn.Close()
+}
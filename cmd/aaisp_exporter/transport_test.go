@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptrace"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// sampleCount returns how many observations h has recorded.
+func sampleCount(t *testing.T, h prometheus.Histogram) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := h.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestTraceConcurrentConnects(t *testing.T) {
+	m := newTransportMetrics()
+	trace := m.trace()
+
+	// Happy Eyeballs dials several addresses at once, and only one wins.
+	dials := []struct {
+		addr string
+		err  error
+	}{
+		{"192.0.2.1:443", nil},
+		{"[2001:db8::1]:443", errors.New("operation was canceled")},
+	}
+	var wg sync.WaitGroup
+	for _, d := range dials {
+		wg.Add(1)
+		go func(addr string, err error) {
+			defer wg.Done()
+			trace.ConnectStart("tcp", addr)
+			trace.ConnectDone("tcp", addr, err)
+		}(d.addr, d.err)
+	}
+	wg.Wait()
+	if n := sampleCount(t, m.connect); n != 1 {
+		t.Errorf("recorded %d connect durations, want 1", n)
+	}
+
+	// A ConnectDone without its ConnectStart isn't timed.
+	trace.ConnectDone("tcp", "192.0.2.2:443", nil)
+	if n := sampleCount(t, m.connect); n != 1 {
+		t.Errorf("recorded %d connect durations, want 1", n)
+	}
+}
+
+func TestTraceFirstByteNeedsWrite(t *testing.T) {
+	m := newTransportMetrics()
+	trace := m.trace()
+	trace.GotFirstResponseByte()
+	if n := sampleCount(t, m.firstByte); n != 0 {
+		t.Errorf("recorded time to first byte without the request being written")
+	}
+	trace.WroteRequest(httptrace.WroteRequestInfo{})
+	trace.GotFirstResponseByte()
+	if n := sampleCount(t, m.firstByte); n != 1 {
+		t.Errorf("recorded %d times to first byte, want 1", n)
+	}
+}
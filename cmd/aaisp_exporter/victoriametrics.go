@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// vmConfig configures the VictoriaMetrics sink.
+type vmConfig struct {
+	url         string
+	username    string
+	password    string
+	extraLabels stringList
+	batchSize   int
+	retries     int
+}
+
+func addVMFlags(fs *flag.FlagSet) *vmConfig {
+	c := new(vmConfig)
+	fs.StringVar(&c.url, "vm.url", "", "push metrics to the VictoriaMetrics or vmagent server at `URL`")
+	fs.StringVar(&c.username, "vm.username", "", "VictoriaMetrics basic auth `username`")
+	fs.StringVar(&c.password, "vm.password", "", "VictoriaMetrics basic auth `password`")
+	fs.Var(&c.extraLabels, "vm.extra-label", "add `name=value` as a label to all metrics; may be repeated")
+	fs.IntVar(&c.batchSize, "vm.batch-size", 1000, "send at most `n` samples per request")
+	fs.IntVar(&c.retries, "vm.retries", 3, "retry failed requests up to `n` times")
+	return c
+}
+
+// sink returns the configured sink, or nil if VictoriaMetrics isn't configured.
+func (c *vmConfig) sink() (sink, error) {
+	if c.url == "" {
+		return nil, nil
+	}
+	for _, l := range c.extraLabels {
+		if name, _, ok := strings.Cut(l, "="); !ok || name == "" {
+			return nil, fmt.Errorf("invalid -vm.extra-label %q: must be name=value", l)
+		}
+	}
+	if c.batchSize < 1 {
+		return nil, fmt.Errorf("-vm.batch-size must be at least 1")
+	}
+	return &vmSink{config: *c, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// vmSink pushes metrics to VictoriaMetrics' JSON line import API, which both
+// single-node VictoriaMetrics and vmagent accept.
+type vmSink struct {
+	config vmConfig
+	client *http.Client
+}
+
+// vmLine is a single time series in the JSON line import format.
+type vmLine struct {
+	Metric     map[string]string `json:"metric"`
+	Values     []float64         `json:"values"`
+	Timestamps []int64           `json:"timestamps"`
+}
+
+func (s *vmSink) name() string { return "victoriametrics" }
+
+// importURL returns the import endpoint, with the extra labels as query
+// parameters in the same way as vmagent's extra_label.
+func (s *vmSink) importURL() string {
+	q := url.Values{}
+	for _, l := range s.config.extraLabels {
+		q.Add("extra_label", l)
+	}
+	u := strings.TrimSuffix(s.config.url, "/") + "/api/v1/import"
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	return u
+}
+
+func (s *vmSink) push(samples []sample) error {
+	for len(samples) > 0 {
+		n := len(samples)
+		if n > s.config.batchSize {
+			n = s.config.batchSize
+		}
+		if err := s.send(samples[:n]); err != nil {
+			return err
+		}
+		samples = samples[n:]
+	}
+	return nil
+}
+
+// send sends a batch of samples, retrying with exponential backoff if the
+// server can't be reached or returns a temporary error.
+func (s *vmSink) send(samples []sample) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, sm := range samples {
+		line := vmLine{
+			Metric:     map[string]string{"__name__": sm.name},
+			Values:     []float64{sm.value},
+			Timestamps: []int64{sm.time.UnixNano() / int64(time.Millisecond)},
+		}
+		for _, l := range sm.labels {
+			line.Metric[l.name] = l.value
+		}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(buf.Bytes())
+		if err == nil || !retry || attempt >= s.config.retries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post makes a single import request, reporting whether a failure is worth
+// retrying.
+func (s *vmSink) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", s.importURL(), bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.config.username != "" {
+		req.SetBasicAuth(s.config.username, s.config.password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("bad response code: %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return false, nil
+}
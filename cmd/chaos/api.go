@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+)
+
+func runAPI(args []string) error {
+	fs := newFlagSet("api", "<path> [key=value ...]")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	path := fs.Arg(0)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	params := url.Values{}
+	for _, arg := range fs.Args()[1:] {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid parameter %q: must be key=value", arg)
+		}
+		params.Add(kv[0], kv[1])
+	}
+
+	if e, ok := chaos.LookupEndpoint(path); ok {
+		if err := e.Validate(params); err != nil {
+			return err
+		}
+	}
+
+	api, err := newAPI()
+	if err != nil {
+		return err
+	}
+	resp, apiErr := api.Do(path, params)
+	if resp == nil {
+		return apiErr
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, resp, "", "  "); err != nil {
+		// Not JSON, so print it as it is.
+		buf.Reset()
+		buf.Write(resp)
+	}
+	buf.WriteByte('\n')
+	buf.WriteTo(os.Stdout)
+	if apiErr != nil {
+		return errors.New("API returned an error")
+	}
+	return nil
+}
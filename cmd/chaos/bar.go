@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+)
+
+const barWidth = 30
+
+// monthElapsed returns the fraction of the calendar month, when quotas are
+// reset, which has elapsed at t.
+func monthElapsed(t time.Time) float64 {
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	end := start.AddDate(0, 1, 0)
+	return float64(t.Sub(start)) / float64(end.Sub(start))
+}
+
+// bar draws a progress bar of used, with a marker at the elapsed fraction of
+// the month.
+func bar(used, elapsed float64) string {
+	clamp := func(f float64) int {
+		n := int(f*barWidth + 0.5)
+		if n < 0 {
+			return 0
+		}
+		if n > barWidth {
+			return barWidth
+		}
+		return n
+	}
+	b := []byte(strings.Repeat("#", clamp(used)) + strings.Repeat("-", barWidth-clamp(used)))
+	if m := clamp(elapsed); m < barWidth {
+		b[m] = '|'
+	}
+	return "[" + string(b) + "]"
+}
+
+// writeQuotaBars writes a progress bar per line showing quota used against how
+// far through the month it is.
+func writeQuotaBars(quotas []chaos.BroadbandQuota) error {
+	elapsed := monthElapsed(time.Now().In(chaos.Location()))
+	color := useColor()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, q := range quotas {
+		if q.QuotaMonthly == 0 {
+			msg := "no monthly quota"
+			if color {
+				msg = colorize(colorDefault, msg)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t\n", q.ID, msg)
+			continue
+		}
+		used := 1 - float64(q.QuotaRemaining)/float64(q.QuotaMonthly)
+		b := bar(used, elapsed)
+		if color {
+			c := colorGreen
+			switch {
+			case used >= 1:
+				c = colorRed
+			case used > elapsed:
+				c = colorAmber
+			}
+			b = colorize(c, b)
+		}
+		pace := "on track"
+		if used > elapsed {
+			pace = "ahead of pace"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%.0f%% used, %.0f%% of month elapsed, %s\n",
+			q.ID, b, 100*used, 100*elapsed, pace)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const timeFormat = "2006-01-02 15:04:05"
+
+func runInfo(args []string) error {
+	fs := newFlagSet("info", "")
+	output := addOutputFlag(fs)
+	raw := addRawFlag(fs)
+	line := addLineFlag(fs)
+	fs.Parse(args)
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	src, err := newSource()
+	if err != nil {
+		return err
+	}
+	lines, err := src.BroadbandInfo()
+	if err != nil {
+		return err
+	}
+	lines = filterInfo(lines, *line)
+
+	if *output == "json" {
+		return writeJSON(lines)
+	}
+	bytes, rate := numberFormatter(*output == "table" && !*raw)
+	header := []string{"ID", "LOGIN", "POSTCODE", "TX RATE", "RX RATE", "QUOTA MONTHLY", "QUOTA REMAINING"}
+	color := *output == "table" && useColor()
+	if color {
+		header[6] = colorize(colorDefault, header[6])
+	}
+	rows := make([][]string, 0, len(lines))
+	for _, l := range lines {
+		rows = append(rows, []string{
+			strconv.Itoa(l.ID),
+			l.Login,
+			l.Postcode,
+			rate(l.TXRate),
+			rate(l.RXRate),
+			bytes(l.QuotaMonthly),
+			bytes(l.QuotaRemaining),
+		})
+		if color {
+			rows[len(rows)-1][6] = colorize(quotaColor(l.QuotaRemaining, l.QuotaMonthly), rows[len(rows)-1][6])
+		}
+	}
+	return writeRows(*output, header, rows)
+}
+
+func runQuota(args []string) error {
+	fs := newFlagSet("quota", "")
+	output := addOutputFlag(fs)
+	raw := addRawFlag(fs)
+	line := addLineFlag(fs)
+	showBar := fs.Bool("bar", false, "show a progress bar of quota used against days elapsed in the month")
+	fs.Parse(args)
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	if *showBar && *output != "table" {
+		return fmt.Errorf("-bar can't be used with %s output", *output)
+	}
+
+	src, err := newSource()
+	if err != nil {
+		return err
+	}
+	quotas, err := fetchQuotas(src, *line)
+	if err != nil {
+		return err
+	}
+
+	if *output == "json" {
+		return writeJSON(quotas)
+	}
+	if *showBar {
+		return writeQuotaBars(quotas)
+	}
+	bytes, _ := numberFormatter(*output == "table" && !*raw)
+	header := []string{"ID", "MONTHLY", "REMAINING", "UPDATED"}
+	color := *output == "table" && useColor()
+	if color {
+		header[2] = colorize(colorDefault, header[2])
+	}
+	rows := make([][]string, 0, len(quotas))
+	for _, q := range quotas {
+		rows = append(rows, []string{
+			strconv.Itoa(q.ID),
+			bytes(q.QuotaMonthly),
+			bytes(q.QuotaRemaining),
+			formatTime(q.QuotaTimestamp.Time),
+		})
+		if color {
+			rows[len(rows)-1][2] = colorize(quotaColor(q.QuotaRemaining, q.QuotaMonthly), rows[len(rows)-1][2])
+		}
+	}
+	return writeRows(*output, header, rows)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(timeFormat)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+)
+
+// lineSource provides broadband line data, either from the API or the cache.
+type lineSource interface {
+	BroadbandInfo() ([]chaos.BroadbandInfo, error)
+	BroadbandQuota() ([]chaos.BroadbandQuota, error)
+}
+
+// cacheEntry is a cached API response.
+type cacheEntry struct {
+	Fetched time.Time       `json:"fetched"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func cachePath(name string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "aaisp-chaos", name+".json"), nil
+}
+
+// writeCache saves v as the latest response for name. Caching is best effort
+// so errors are ignored.
+func writeCache(name string, v interface{}) {
+	path, err := cachePath(name)
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	b, err := json.Marshal(cacheEntry{Fetched: time.Now(), Data: data})
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	ioutil.WriteFile(path, b, 0600)
+}
+
+// readCache loads the latest response for name into v, returning when it was
+// fetched.
+func readCache(name string, v interface{}) (time.Time, error) {
+	path, err := cachePath(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return time.Time{}, err
+	}
+	return e.Fetched, json.Unmarshal(e.Data, v)
+}
+
+// cachedSource saves every successful API response, and falls back to the
+// saved response when the API can't be reached. When offline, the API isn't
+// called at all.
+type cachedSource struct {
+	api     *chaos.API
+	offline bool
+}
+
+func (s cachedSource) BroadbandInfo() ([]chaos.BroadbandInfo, error) {
+	var lines []chaos.BroadbandInfo
+	err := s.fetch("info", &lines, func() (err error) {
+		lines, err = s.api.BroadbandInfo()
+		return err
+	})
+	return lines, err
+}
+
+func (s cachedSource) BroadbandQuota() ([]chaos.BroadbandQuota, error) {
+	var quotas []chaos.BroadbandQuota
+	err := s.fetch("quota", &quotas, func() (err error) {
+		quotas, err = s.api.BroadbandQuota()
+		return err
+	})
+	return quotas, err
+}
+
+// fetch calls live, which stores its result in v, and caches the result. If
+// offline, or live fails, v is loaded from the cache instead.
+func (s cachedSource) fetch(name string, v interface{}, live func() error) error {
+	if !s.offline {
+		err := live()
+		if err == nil {
+			writeCache(name, v)
+			return nil
+		}
+		if warnPartial(err) == nil {
+			// Show the lines which could be decoded, but don't cache them.
+			return nil
+		}
+		fetched, cerr := readCache(name, v)
+		if cerr != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%v\nShowing cached data from %s\n", err, fetched.Format(timeFormat))
+		return nil
+	}
+
+	fetched, err := readCache(name, v)
+	if err != nil {
+		return fmt.Errorf("no cached data: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Showing cached data from %s\n", fetched.Format(timeFormat))
+	return nil
+}
+
+// newSource returns a lineSource for commands which display line data.
+func newSource() (lineSource, error) {
+	if *offline {
+		return cachedSource{offline: true}, nil
+	}
+	api, err := newAPI()
+	if err != nil {
+		return nil, err
+	}
+	return cachedSource{api: api}, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Exit codes for the check command, following the Nagios plugin convention.
+const (
+	checkOK = iota
+	checkWarning
+	checkCritical
+	checkUnknown
+)
+
+var checkStatus = [...]string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// threshold is a quota threshold, either a percentage of the monthly quota or
+// an absolute number of bytes.
+type threshold struct {
+	value   float64
+	percent bool
+}
+
+func (t threshold) String() string {
+	if t.percent {
+		return strconv.FormatFloat(t.value, 'f', -1, 64) + "%"
+	}
+	return strconv.FormatFloat(t.value, 'f', -1, 64)
+}
+
+func (t *threshold) Set(s string) error {
+	if strings.HasSuffix(s, "%") {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil {
+			return err
+		}
+		*t = threshold{value: v, percent: true}
+		return nil
+	}
+	v, err := parseBytes(s)
+	if err != nil {
+		return err
+	}
+	*t = threshold{value: float64(v)}
+	return nil
+}
+
+// breached reports whether remaining is at or below the threshold.
+func (t threshold) breached(remaining, monthly int) bool {
+	if t.percent {
+		return float64(remaining) <= float64(monthly)*t.value/100
+	}
+	return float64(remaining) <= t.value
+}
+
+// parseBytes parses a byte count with an optional decimal unit suffix, such as
+// "50GB" or "1.5TB".
+func parseBytes(s string) (int, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	mult := 1.0
+	for i, prefix := range []string{"KB", "MB", "GB", "TB", "PB"} {
+		if strings.HasSuffix(s, prefix) {
+			s = strings.TrimSuffix(s, prefix)
+			for j := 0; j <= i; j++ {
+				mult *= 1000
+			}
+			break
+		}
+	}
+	s = strings.TrimSuffix(strings.TrimSpace(s), "B")
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid byte count %q", s)
+	}
+	return int(v * mult), nil
+}
+
+func runCheck(args []string) error {
+	fs := newFlagSet("check", "")
+	warn := threshold{value: 20, percent: true}
+	crit := threshold{value: 5, percent: true}
+	fs.Var(&warn, "quota-warn", "warn when remaining quota is at or below `threshold` (percentage or bytes)")
+	fs.Var(&crit, "quota-crit", "critical when remaining quota is at or below `threshold` (percentage or bytes)")
+	line := addLineFlag(fs)
+	fs.Parse(args)
+
+	status, summary := check(warn, crit, *line)
+	fmt.Printf("QUOTA %s - %s\n", checkStatus[status], summary)
+	os.Exit(status)
+	return nil
+}
+
+func check(warn, crit threshold, line lineFilter) (int, string) {
+	api, err := newAPI()
+	if err != nil {
+		return checkUnknown, err.Error()
+	}
+	quotas, err := fetchQuotas(api, line)
+	if err != nil {
+		return checkUnknown, err.Error()
+	}
+	if line != "" && len(quotas) == 0 {
+		// The line being checked doesn't exist, so its state is unknown.
+		return checkUnknown, fmt.Sprintf("no line matches -line %s", line)
+	}
+
+	status := checkOK
+	var details []string
+	for _, q := range quotas {
+		if q.QuotaMonthly == 0 {
+			continue
+		}
+		s := checkOK
+		switch {
+		case crit.breached(q.QuotaRemaining, q.QuotaMonthly):
+			s = checkCritical
+		case warn.breached(q.QuotaRemaining, q.QuotaMonthly):
+			s = checkWarning
+		}
+		if s > status {
+			status = s
+		}
+		details = append(details, fmt.Sprintf("%d: %.1f%% remaining (%s)",
+			q.ID, 100*float64(q.QuotaRemaining)/float64(q.QuotaMonthly), formatBytes(q.QuotaRemaining)))
+	}
+	if len(details) == 0 {
+		return checkOK, "no lines with a monthly quota"
+	}
+	return status, strings.Join(details, ", ")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+)
+
+// runCollectd prints line data as PUTVAL commands for collectd's exec plugin.
+// collectd sets COLLECTD_HOSTNAME and COLLECTD_INTERVAL in the environment of
+// the programs it runs.
+func runCollectd(args []string) error {
+	host := os.Getenv("COLLECTD_HOSTNAME")
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	// collectd's default interval is far more often than quotas change, so
+	// only honour its interval if it's at least a minute.
+	every := 5 * time.Minute
+	if s, err := strconv.ParseFloat(os.Getenv("COLLECTD_INTERVAL"), 64); err == nil && s >= 60 {
+		every = time.Duration(s * float64(time.Second))
+	}
+
+	fs := newFlagSet("collectd", "")
+	fs.StringVar(&host, "host", host, "`hostname` to report values for")
+	interval := fs.Duration("interval", every, "poll the API every `interval`")
+	once := fs.Bool("once", false, "print values once and exit")
+	fs.Parse(args)
+
+	api, err := newAPI()
+	if err != nil {
+		return err
+	}
+
+	for {
+		lines, err := api.BroadbandInfo()
+		if err = warnPartial(err); err != nil {
+			if *once {
+				return err
+			}
+			// collectd logs anything written to stderr.
+			fmt.Fprintf(os.Stderr, "chaos: %v\n", err)
+		} else {
+			writePutvals(os.Stdout, host, *interval, lines)
+		}
+		if *once {
+			return nil
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// writePutvals writes one PUTVAL command per value using the "aaisp" plugin,
+// with the line ID as the plugin instance and types from collectd's types.db.
+func writePutvals(w io.Writer, host string, interval time.Duration, lines []chaos.BroadbandInfo) {
+	values := []struct {
+		typ      string
+		instance string
+		value    func(chaos.BroadbandInfo) int
+	}{
+		{"bytes", "quota_remaining", func(l chaos.BroadbandInfo) int { return l.QuotaRemaining }},
+		{"bytes", "quota_monthly", func(l chaos.BroadbandInfo) int { return l.QuotaMonthly }},
+		{"bitrate", "tx", func(l chaos.BroadbandInfo) int { return l.TXRate }},
+		{"bitrate", "rx", func(l chaos.BroadbandInfo) int { return l.RXRate }},
+	}
+	for _, line := range lines {
+		for _, v := range values {
+			fmt.Fprintf(w, "PUTVAL \"%s/aaisp-%d/%s-%s\" interval=%d N:%d\n",
+				host, line.ID, v.typ, v.instance, int(interval.Seconds()), v.value(line))
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/term"
+)
+
+// ANSI colour codes. Every code is the same length so that tabwriter, which
+// counts escape sequences as text, still aligns coloured columns.
+const (
+	colorDefault = "\x1b[39m"
+	colorRed     = "\x1b[31m"
+	colorGreen   = "\x1b[32m"
+	colorAmber   = "\x1b[33m"
+	colorReset   = "\x1b[0m"
+)
+
+// useColor reports whether output should be coloured: stdout must be a
+// terminal and colour must not have been disabled with -no-color or the
+// NO_COLOR environment variable.
+func useColor() bool {
+	if *noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+func colorize(color, s string) string {
+	return color + s + colorReset
+}
+
+// quotaColor returns the colour for a quota level: green when plenty remains,
+// amber below 20% and red below 5%.
+func quotaColor(remaining, monthly int) string {
+	if monthly == 0 {
+		return colorDefault
+	}
+	switch pct := 100 * float64(remaining) / float64(monthly); {
+	case pct <= 5:
+		return colorRed
+	case pct <= 20:
+		return colorAmber
+	}
+	return colorGreen
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+	provider "github.com/jamesog/aaisp-chaos/credentials"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/term"
+)
+
+// keyringService and keyringUser identify the credentials stored by the login
+// command in the OS keyring.
+const (
+	keyringService = "github.com/jamesog/aaisp-chaos"
+	keyringUser    = "control"
+)
+
+// credentials are control login credentials.
+type credentials struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+// envCredentials returns credentials from the environment, if set.
+func envCredentials() (credentials, bool) {
+	c := credentials{
+		Login:    os.Getenv("CHAOS_CONTROL_LOGIN"),
+		Password: os.Getenv("CHAOS_CONTROL_PASSWORD"),
+	}
+	return c, c.Login != "" && c.Password != ""
+}
+
+// fileCredentials returns credentials from an age-encrypted file. It's
+// decrypted with the identity file named by CHAOS_AGE_IDENTITY_FILE, or else
+// with the passphrase in CHAOS_AGE_PASSPHRASE or entered at the terminal.
+func fileCredentials(path string) (credentials, error) {
+	f := provider.AgeFile{
+		Path:         path,
+		IdentityFile: os.Getenv("CHAOS_AGE_IDENTITY_FILE"),
+		Passphrase: func() (string, error) {
+			if pass := os.Getenv("CHAOS_AGE_PASSPHRASE"); pass != "" {
+				return pass, nil
+			}
+			if !term.IsTerminal(int(os.Stdin.Fd())) {
+				return "", errors.New("set CHAOS_AGE_IDENTITY_FILE or CHAOS_AGE_PASSPHRASE to decrypt the credentials file")
+			}
+			fmt.Fprintf(os.Stderr, "Passphrase for %s: ", path)
+			pass, err := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Fprintln(os.Stderr)
+			return string(pass), err
+		},
+	}
+	auth, err := f.Credentials(context.Background())
+	if err != nil {
+		return credentials{}, err
+	}
+	return credentials{Login: auth.ControlLogin, Password: auth.ControlPassword}, nil
+}
+
+// keyringCredentials returns credentials saved in the OS keyring by the login
+// command.
+func keyringCredentials() (credentials, error) {
+	var c credentials
+	s, err := keyring.Get(keyringService, keyringUser)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal([]byte(s), &c)
+	return c, err
+}
+
+func saveCredentials(c credentials) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return keyring.Set(keyringService, keyringUser, string(b))
+}
+
+// errNoCredentials is returned by findCredentials when there are no
+// credentials in the environment or the keyring.
+var errNoCredentials = errors.New("no credentials found: run 'chaos login' or set CHAOS_CONTROL_LOGIN and CHAOS_CONTROL_PASSWORD")
+
+// findCredentials returns credentials from the -credentials-file or the
+// environment, falling back to the OS keyring, along with where they were
+// found. It doesn't prompt for them.
+func findCredentials() (credentials, string, error) {
+	if *credFile != "" {
+		c, err := fileCredentials(*credFile)
+		return c, *credFile, err
+	}
+	if c, ok := envCredentials(); ok {
+		return c, "the environment", nil
+	}
+	c, err := keyringCredentials()
+	if err != nil {
+		return c, "", errNoCredentials
+	}
+	return c, "the keyring", nil
+}
+
+// loadCredentials returns credentials as found by findCredentials. If there
+// aren't any and stdin is a terminal, the user is prompted for them and
+// offered the chance to save them.
+func loadCredentials() (credentials, error) {
+	c, _, err := findCredentials()
+	if !errors.Is(err, errNoCredentials) {
+		return c, err
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return c, err
+	}
+
+	fmt.Fprintln(os.Stderr, "No credentials found.")
+	c, err = promptCredentials()
+	if err != nil {
+		return c, err
+	}
+	if confirm("Save credentials to the keyring?") {
+		if err := saveCredentials(c); err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't save credentials: %v\n", err)
+		}
+	}
+	return c, nil
+}
+
+// confirm asks a yes/no question on the terminal, defaulting to no.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// promptCredentials reads credentials from the terminal, without echoing the
+// password.
+func promptCredentials() (credentials, error) {
+	var c credentials
+	r := bufio.NewReader(os.Stdin)
+	fmt.Fprint(os.Stderr, "Control login: ")
+	login, err := r.ReadString('\n')
+	if err != nil {
+		return c, err
+	}
+	c.Login = strings.TrimSpace(login)
+
+	fmt.Fprint(os.Stderr, "Control password: ")
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return c, err
+	}
+	c.Password = string(password)
+
+	if c.Login == "" || c.Password == "" {
+		return c, errors.New("login and password must not be empty")
+	}
+	return c, nil
+}
+
+func runLogin(args []string) error {
+	fs := newFlagSet("login", "")
+	fs.Parse(args)
+
+	c, err := promptCredentials()
+	if err != nil {
+		return err
+	}
+
+	// Check the credentials work before saving them.
+	api := chaos.New(chaos.Auth{ControlLogin: c.Login, ControlPassword: c.Password})
+	if *endpoint != "" {
+		api.Endpoint = *endpoint
+	}
+	if _, err := api.BroadbandQuota(); err != nil {
+		return fmt.Errorf("checking credentials: %w", err)
+	}
+
+	if err := saveCredentials(c); err != nil {
+		return fmt.Errorf("saving credentials to keyring: %w", err)
+	}
+	fmt.Fprintln(os.Stderr, "Credentials saved to the keyring.")
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+)
+
+// finding is the result of a single diagnostic check.
+type finding struct {
+	ok     bool
+	check  string
+	detail string
+	hint   string
+}
+
+func (f finding) print() {
+	status := "OK  "
+	if !f.ok {
+		status = "FAIL"
+	}
+	fmt.Printf("[%s] %s: %s\n", status, f.check, f.detail)
+	if !f.ok && f.hint != "" {
+		fmt.Printf("       %s\n", f.hint)
+	}
+}
+
+func runDoctor(args []string) error {
+	fs := newFlagSet("doctor", "")
+	fs.Parse(args)
+
+	api := chaos.New(chaos.Auth{})
+	if *endpoint != "" {
+		api.Endpoint = *endpoint
+	}
+
+	var findings []finding
+	add := func(f finding) {
+		f.print()
+		findings = append(findings, f)
+	}
+
+	add(checkTimezone())
+	creds, f := checkCredentials()
+	add(f)
+	u, f := checkEndpointURL(api.Endpoint)
+	add(f)
+	reachable := false
+	if u != nil {
+		f := checkReachable(u)
+		add(f)
+		reachable = f.ok
+	}
+	if reachable && u.Scheme == "https" {
+		add(checkTLS(u))
+	}
+	if reachable {
+		add(checkClock(u))
+	}
+	if reachable && creds.Login != "" {
+		api = chaos.New(chaos.Auth{ControlLogin: creds.Login, ControlPassword: creds.Password})
+		api.Endpoint = u.String()
+		add(checkResponse(api))
+	}
+
+	for _, f := range findings {
+		if !f.ok {
+			return fmt.Errorf("%s check failed", f.check)
+		}
+	}
+	return nil
+}
+
+func checkTimezone() finding {
+	f := finding{check: "timezone database"}
+	if _, err := time.LoadLocation("Europe/London"); err != nil {
+		f.detail = err.Error()
+		f.hint = "API timestamps are UK local time; install your OS's tzdata package or set ZONEINFO, otherwise local time is assumed"
+		return f
+	}
+	f.ok, f.detail = true, "Europe/London is available"
+	return f
+}
+
+func checkCredentials() (credentials, finding) {
+	f := finding{check: "credentials"}
+	c, source, err := findCredentials()
+	switch {
+	case errors.Is(err, errNoCredentials):
+		f.detail = "not found in the environment or keyring"
+		f.hint = "run 'chaos login' or set CHAOS_CONTROL_LOGIN and CHAOS_CONTROL_PASSWORD"
+		return c, f
+	case err != nil:
+		f.detail = err.Error()
+		f.hint = "check the -credentials-file option, and CHAOS_AGE_IDENTITY_FILE or CHAOS_AGE_PASSPHRASE"
+		return c, f
+	}
+	f.ok, f.detail = true, "found in "+source
+	return c, f
+}
+
+func checkEndpointURL(endpoint string) (*url.URL, finding) {
+	f := finding{check: "endpoint"}
+	u, err := url.Parse(endpoint)
+	if err == nil && (u.Scheme != "http" && u.Scheme != "https" || u.Host == "") {
+		err = fmt.Errorf("%q is not an http or https URL", endpoint)
+	}
+	if err != nil {
+		f.detail = err.Error()
+		f.hint = "check the -endpoint option"
+		return nil, f
+	}
+	f.ok, f.detail = true, u.String()
+	return u, f
+}
+
+// hostPort returns the host and port to connect to for u.
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+func checkReachable(u *url.URL) finding {
+	f := finding{check: "reachability"}
+	addrs, err := net.LookupHost(u.Hostname())
+	if err != nil {
+		f.detail = err.Error()
+		f.hint = "check your DNS resolver and internet connection"
+		return f
+	}
+	conn, err := net.DialTimeout("tcp", hostPort(u), 10*time.Second)
+	if err != nil {
+		f.detail = err.Error()
+		f.hint = "check your internet connection and any firewall or proxy"
+		return f
+	}
+	conn.Close()
+	f.ok, f.detail = true, fmt.Sprintf("connected to %s (%d addresses)", hostPort(u), len(addrs))
+	return f
+}
+
+func checkTLS(u *url.URL) finding {
+	f := finding{check: "TLS"}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", hostPort(u), &tls.Config{ServerName: u.Hostname()})
+	if err != nil {
+		f.detail = err.Error()
+		f.hint = "check the system's CA certificates are installed and up to date, and that nothing is intercepting TLS"
+		return f
+	}
+	defer conn.Close()
+	cert := conn.ConnectionState().PeerCertificates[0]
+	f.ok, f.detail = true, fmt.Sprintf("certificate for %s valid until %s", cert.Subject.CommonName, cert.NotAfter.Format(timeFormat))
+	return f
+}
+
+func checkClock(u *url.URL) finding {
+	f := finding{check: "clock"}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Head(u.String())
+	if err != nil {
+		f.detail = err.Error()
+		return f
+	}
+	resp.Body.Close()
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		f.ok, f.detail = true, "server didn't send a Date header; skipped"
+		return f
+	}
+	skew := time.Since(date).Round(time.Second)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > time.Minute {
+		f.detail = fmt.Sprintf("local clock differs from the server by %s", skew)
+		f.hint = "enable NTP time synchronisation; quota timestamps and billing month calculations depend on it"
+		return f
+	}
+	f.ok, f.detail = true, fmt.Sprintf("within %s of the server", skew)
+	return f
+}
+
+func checkResponse(api *chaos.API) finding {
+	f := finding{check: "API response"}
+	lines, err := api.BroadbandInfo()
+	if err != nil {
+		f.detail = err.Error()
+		f.hint = "if this is a login error, check the credentials work at https://control.aa.net.uk/"
+		return f
+	}
+	f.ok, f.detail = true, fmt.Sprintf("decoded information for %d lines", len(lines))
+	return f
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+)
+
+func runExport(args []string) error {
+	fs := newFlagSet("export", "")
+	path := fs.String("db", "aaisp-history.db", "SQLite database `file` recorded by 'chaos record'")
+	from := fs.String("from", "", "export samples from `YYYY-MM-DD` (default the start of the history)")
+	to := fs.String("to", "", "export samples up to and including `YYYY-MM-DD` (default the end of the history)")
+	out := fs.String("o", "", "write to `file` instead of standard output")
+	output := fs.String("output", "csv", "output `format` (csv, tsv)")
+	line := addLineFlag(fs)
+	fs.Parse(args)
+
+	if *output != "csv" && *output != "tsv" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+	// Times are stored in UTC, which sorts lexically, so the range can be
+	// compared as strings. The end is exclusive.
+	start, end := "", "9999"
+	if *from != "" {
+		t, err := time.ParseInLocation("2006-01-02", *from, chaos.Location())
+		if err != nil {
+			return fmt.Errorf("invalid -from %q: must be YYYY-MM-DD", *from)
+		}
+		start = t.UTC().Format(historyTime)
+	}
+	if *to != "" {
+		t, err := time.ParseInLocation("2006-01-02", *to, chaos.Location())
+		if err != nil {
+			return fmt.Errorf("invalid -to %q: must be YYYY-MM-DD", *to)
+		}
+		end = t.AddDate(0, 0, 1).UTC().Format(historyTime)
+	}
+
+	db, err := openHistory(*path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if *out == "" {
+		return exportHistory(os.Stdout, db, *output, start, end, *line)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := exportHistory(f, db, *output, start, end, *line); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// exportHistory writes recorded rows between start and end as CSV or TSV, with
+// the same columns as the database.
+func exportHistory(w io.Writer, db *sql.DB, format, start, end string, f lineFilter) error {
+	rs, err := db.Query(`SELECT time, line_id, login, postcode, tx_rate, rx_rate, tx_rate_adjusted,
+		quota_monthly, quota_remaining, COALESCE(quota_timestamp, '')
+		FROM broadband WHERE time >= ? AND time < ? ORDER BY time, line_id`, start, end)
+	if err != nil {
+		return err
+	}
+	defer rs.Close()
+
+	cw := csv.NewWriter(w)
+	if format == "tsv" {
+		cw.Comma = '\t'
+	}
+	cw.Write([]string{"time", "line_id", "login", "postcode", "tx_rate", "rx_rate", "tx_rate_adjusted",
+		"quota_monthly", "quota_remaining", "quota_timestamp"})
+	for rs.Next() {
+		var (
+			t, login, postcode, quotaTime                        string
+			id, tx, rx, txAdjusted, quotaMonthly, quotaRemaining int
+		)
+		err := rs.Scan(&t, &id, &login, &postcode, &tx, &rx, &txAdjusted, &quotaMonthly, &quotaRemaining, &quotaTime)
+		if err != nil {
+			return err
+		}
+		if !f.matches(id, login) {
+			continue
+		}
+		cw.Write([]string{t, strconv.Itoa(id), login, postcode, strconv.Itoa(tx), strconv.Itoa(rx),
+			strconv.Itoa(txAdjusted), strconv.Itoa(quotaMonthly), strconv.Itoa(quotaRemaining), quotaTime})
+	}
+	if err := rs.Err(); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
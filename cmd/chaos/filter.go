@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+)
+
+// lineFilter selects lines by ID or by a substring of their login. The empty
+// filter selects every line.
+type lineFilter string
+
+// addLineFlag adds the -line flag to fs.
+func addLineFlag(fs *flag.FlagSet) *lineFilter {
+	f := new(lineFilter)
+	fs.StringVar((*string)(f), "line", "", "only show the line with this `ID or login` (a substring of the login matches)")
+	return f
+}
+
+func (f lineFilter) matches(id int, login string) bool {
+	if f == "" || strconv.Itoa(id) == string(f) {
+		return true
+	}
+	return login != "" && strings.Contains(login, string(f))
+}
+
+// ids returns the IDs of the lines matching the filter, for responses which
+// don't include the login. It only fetches line information if the filter
+// isn't an ID. A nil map means every line matches.
+func (f lineFilter) ids(src lineSource) (map[int]bool, error) {
+	if f == "" {
+		return nil, nil
+	}
+	if id, err := strconv.Atoi(string(f)); err == nil {
+		return map[int]bool{id: true}, nil
+	}
+	lines, err := src.BroadbandInfo()
+	if err := warnPartial(err); err != nil {
+		return nil, err
+	}
+	ids := make(map[int]bool)
+	for _, l := range lines {
+		if f.matches(l.ID, l.Login) {
+			ids[l.ID] = true
+		}
+	}
+	return ids, nil
+}
+
+// filterInfo returns the lines matching f. The result is never nil, so it's
+// written as an empty JSON array when nothing matches.
+func filterInfo(lines []chaos.BroadbandInfo, f lineFilter) []chaos.BroadbandInfo {
+	filtered := []chaos.BroadbandInfo{}
+	for _, l := range lines {
+		if f.matches(l.ID, l.Login) {
+			filtered = append(filtered, l)
+		}
+	}
+	return filtered
+}
+
+func filterQuota(quotas []chaos.BroadbandQuota, ids map[int]bool) []chaos.BroadbandQuota {
+	if ids == nil {
+		return quotas
+	}
+	filtered := []chaos.BroadbandQuota{}
+	for _, q := range quotas {
+		if ids[q.ID] {
+			filtered = append(filtered, q)
+		}
+	}
+	return filtered
+}
+
+// fetchQuotas fetches the broadband quota for the lines matching f.
+func fetchQuotas(src lineSource, f lineFilter) ([]chaos.BroadbandQuota, error) {
+	ids, err := f.ids(src)
+	if err != nil {
+		return nil, err
+	}
+	q, err := src.BroadbandQuota()
+	if err := warnPartial(err); err != nil {
+		return nil, err
+	}
+	return filterQuota(q, ids), nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// formatBytes formats n bytes using decimal units, as used for quotas.
+func formatBytes(n int) string {
+	const unit = 1000
+	if n < unit && n > -unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	f := float64(n)
+	for _, prefix := range "kMGTPE" {
+		f /= unit
+		if f < unit && f > -unit {
+			return fmt.Sprintf("%.1f %cB", f, prefix)
+		}
+	}
+	return strconv.Itoa(n)
+}
+
+// formatRate formats a rate of n bits per second.
+func formatRate(n int) string {
+	switch {
+	case n >= 1e9:
+		return fmt.Sprintf("%.1f Gb/s", float64(n)/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.1f Mb/s", float64(n)/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1f kb/s", float64(n)/1e3)
+	}
+	return fmt.Sprintf("%d b/s", n)
+}
+
+// numberFormatter returns functions for formatting byte counts and rates,
+// either human-readable or exact.
+func numberFormatter(human bool) (bytes, rate func(int) string) {
+	if !human {
+		return strconv.Itoa, strconv.Itoa
+	}
+	return formatBytes, formatRate
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+// migrations are applied to the history database in order. Each is applied
+// once, in a transaction, and its index+1 recorded as the schema version.
+// Never edit a released migration; append a new one instead.
+var migrations = []string{
+	`CREATE TABLE broadband (
+		time             TEXT    NOT NULL,
+		line_id          INTEGER NOT NULL,
+		login            TEXT    NOT NULL,
+		postcode         TEXT    NOT NULL,
+		tx_rate          INTEGER NOT NULL,
+		rx_rate          INTEGER NOT NULL,
+		tx_rate_adjusted INTEGER NOT NULL,
+		quota_monthly    INTEGER NOT NULL,
+		quota_remaining  INTEGER NOT NULL,
+		quota_timestamp  TEXT
+	);
+	CREATE INDEX broadband_line_time ON broadband (line_id, time);`,
+}
+
+// openHistory opens the history database at path, creating it and applying
+// any outstanding migrations.
+func openHistory(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating %s: %w", path, err)
+	}
+	return db, nil
+}
+
+func migrate(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+	var version int
+	err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this program supports (%d)", version, len(migrations))
+	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_version (version) VALUES (?)`, i+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// historyTime is the format times are stored in. It sorts lexically.
+const historyTime = time.RFC3339
+
+// recordLines appends a row per line to the history database.
+func recordLines(db *sql.DB, now time.Time, lines []chaos.BroadbandInfo) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT INTO broadband
+		(time, line_id, login, postcode, tx_rate, rx_rate, tx_rate_adjusted, quota_monthly, quota_remaining, quota_timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, l := range lines {
+		var quotaTime interface{}
+		if !l.QuotaTimestamp.IsZero() {
+			quotaTime = l.QuotaTimestamp.UTC().Format(historyTime)
+		}
+		_, err := stmt.Exec(now.UTC().Format(historyTime), l.ID, l.Login, l.Postcode,
+			l.TXRate, l.RXRate, l.TXRateAdjusted, l.QuotaMonthly, l.QuotaRemaining, quotaTime)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
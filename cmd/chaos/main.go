@@ -0,0 +1,159 @@
+// Command chaos is a command-line client for the Andrews and Arnold CHAOS API.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+	"github.com/jamesog/aaisp-chaos/vcr"
+)
+
+// command is a chaos subcommand.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var (
+	commands []command
+
+	endpoint = flag.String("endpoint", "", "CHAOS API `URL`")
+	noColor  = flag.Bool("no-color", false, "disable coloured output")
+	offline  = flag.Bool("offline", false, "show the last cached data instead of calling the API")
+	credFile = flag.String("credentials-file", "", "read credentials from age-encrypted `file`")
+	readOnly = flag.Bool("read-only", false, "refuse API calls which might make changes")
+	auditLog = flag.String("audit-log", "", "append a JSON record of each API call which might make changes to `file`")
+	record   = flag.String("vcr.record", "", "record API responses to cassette `file`")
+	replay   = flag.String("vcr.replay", "", "answer API requests from cassette `file` instead of calling the API")
+)
+
+func init() {
+	commands = []command{
+		{"info", "Show broadband line information", runInfo},
+		{"quota", "Show broadband quota", runQuota},
+		{"check", "Check remaining quota against thresholds", runCheck},
+		{"record", "Record line history to a SQLite database", runRecord},
+		{"report", "Generate a monthly usage report from recorded history", runReport},
+		{"export", "Export recorded history as CSV", runExport},
+		{"collectd", "Print values for collectd's exec plugin", runCollectd},
+		{"zabbix", "Print Zabbix discovery data or send values to Zabbix", runZabbix},
+		{"login", "Save credentials in the OS keyring", runLogin},
+		{"doctor", "Diagnose common configuration and connectivity problems", runDoctor},
+		{"api", "Call an arbitrary API endpoint and print the response", runAPI},
+	}
+}
+
+func usage() {
+	o := flag.CommandLine.Output()
+	fmt.Fprintf(o, "Usage:\n    %s [global options] <command> [options]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(o, "    %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprint(o, "\nGlobal options:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(o, "\nRun '%s <command> -h' for a command's options.\n", os.Args[0])
+	fmt.Fprint(o, "\nCredentials are read from the environment variables CHAOS_CONTROL_LOGIN and CHAOS_CONTROL_PASSWORD,\nfrom the OS keyring after running 'chaos login', or from an age-encrypted -credentials-file.\n")
+}
+
+// newFlagSet returns a FlagSet for a subcommand with a consistent usage message.
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		o := fs.Output()
+		fmt.Fprintf(o, "Usage:\n    %s %s [options] %s\n\nOptions:\n", os.Args[0], name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// newAPI returns an API client using credentials from the environment or the
+// OS keyring.
+func newAPI() (*chaos.API, error) {
+	if *replay != "" {
+		t, err := vcr.New(*replay, vcr.Replay)
+		if err != nil {
+			return nil, err
+		}
+		// Replayed requests don't need credentials.
+		api := chaos.New(chaos.Auth{})
+		api.Transport = t
+		api.OnDecodeWarning = warnDecode
+		return api, nil
+	}
+
+	c, err := loadCredentials()
+	if err != nil {
+		return nil, err
+	}
+	api := chaos.New(chaos.Auth{
+		ControlLogin:    c.Login,
+		ControlPassword: c.Password,
+	})
+	if *endpoint != "" {
+		api.Endpoint = *endpoint
+	}
+	api.ReadOnly = *readOnly
+	if *auditLog != "" {
+		f, err := os.OpenFile(*auditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		api.Audit = chaos.NewAuditLog(f)
+	}
+	api.OnDecodeWarning = warnDecode
+	if *record != "" {
+		t, err := vcr.New(*record, vcr.Record)
+		if err != nil {
+			return nil, err
+		}
+		api.Transport = t
+	}
+	return api, nil
+}
+
+// warnDecode reports a field in an API response which couldn't be decoded.
+func warnDecode(w chaos.DecodeWarning) {
+	fmt.Fprintf(os.Stderr, "chaos: warning: %v\n", w)
+}
+
+// warnPartial reports each line which couldn't be decoded if err is a
+// *chaos.PartialError, and returns nil so the lines which could be decoded
+// are used. Any other error is returned as it is.
+func warnPartial(err error) error {
+	var partial *chaos.PartialError
+	if !errors.As(err, &partial) {
+		return err
+	}
+	for _, e := range partial.Errs {
+		fmt.Fprintf(os.Stderr, "chaos: warning: %v\n", e)
+	}
+	return nil
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := flag.Arg(0)
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		if err := c.run(flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "chaos: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "chaos: unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// addOutputFlag adds the -output flag to fs.
+func addOutputFlag(fs *flag.FlagSet) *string {
+	return fs.String("output", "table", "output `format` (table, json, csv, tsv)")
+}
+
+// addRawFlag adds the -raw flag to fs.
+func addRawFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("raw", false, "show exact byte counts and rates in table output")
+}
+
+// checkOutput returns an error if format isn't a supported output format.
+func checkOutput(format string) error {
+	switch format {
+	case "table", "json", "csv", "tsv":
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+// writeJSON writes v to stdout as indented JSON.
+func writeJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeRows writes tabular data to stdout in the given format.
+func writeRows(format string, header []string, rows [][]string) error {
+	switch format {
+	case "csv", "tsv":
+		w := csv.NewWriter(os.Stdout)
+		if format == "tsv" {
+			w.Comma = '\t'
+		}
+		w.Write(header)
+		w.WriteAll(rows)
+		return w.Error()
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
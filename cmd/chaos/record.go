@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+func runRecord(args []string) error {
+	fs := newFlagSet("record", "")
+	path := fs.String("db", "aaisp-history.db", "SQLite database `file` to record to")
+	interval := fs.Duration("interval", 15*time.Minute, "poll the API every `interval`")
+	once := fs.Bool("once", false, "record once and exit, e.g. when run from cron")
+	fs.Parse(args)
+
+	db, err := openHistory(*path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	api, err := newAPI()
+	if err != nil {
+		return err
+	}
+
+	record := func() error {
+		lines, err := api.BroadbandInfo()
+		if err := warnPartial(err); err != nil {
+			return err
+		}
+		return recordLines(db, time.Now(), lines)
+	}
+
+	if *once {
+		return record()
+	}
+	for {
+		if err := record(); err != nil {
+			// Keep going; the API or line may be down temporarily.
+			fmt.Fprintf(os.Stderr, "%s: %v\n", time.Now().Format(timeFormat), err)
+		}
+		time.Sleep(*interval)
+	}
+}
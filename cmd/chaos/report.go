@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+)
+
+// topUp is an increase in remaining quota part way through a month.
+type topUp struct {
+	Time   time.Time
+	Amount int
+}
+
+// dayUsage is the data used on a single day.
+type dayUsage struct {
+	Date time.Time
+	Used int
+	// UsedPercent is the proportion of the monthly quota used by the end of
+	// the day, and ElapsedPercent the proportion of the month gone by.
+	UsedPercent    float64
+	ElapsedPercent float64
+}
+
+// lineReport summarises a line's usage over a month.
+type lineReport struct {
+	ID      int
+	Login   string
+	Monthly int
+	Used    int
+	TopUps  []topUp
+	Days    []dayUsage
+}
+
+// historyRow is the subset of a history row needed for a report.
+type historyRow struct {
+	time      time.Time
+	id        int
+	login     string
+	monthly   int
+	remaining int
+}
+
+func runReport(args []string) error {
+	fs := newFlagSet("report", "")
+	path := fs.String("db", "aaisp-history.db", "SQLite database `file` recorded by 'chaos record'")
+	month := fs.String("month", "", "report on `YYYY-MM` (default last month)")
+	out := fs.String("o", "", "write the report to `file` instead of standard output")
+	mailTo := fs.String("mail-to", "", "email the report to comma-separated `addresses`")
+	mailFrom := fs.String("mail-from", "", "`address` to send the report from")
+	smtpAddr := fs.String("smtp", "localhost:25", "SMTP server `host:port`")
+	line := addLineFlag(fs)
+	fs.Parse(args)
+
+	start := time.Now().In(chaos.Location())
+	start = time.Date(start.Year(), start.Month()-1, 1, 0, 0, 0, 0, start.Location())
+	if *month != "" {
+		var err error
+		start, err = time.ParseInLocation("2006-01", *month, chaos.Location())
+		if err != nil {
+			return fmt.Errorf("invalid -month %q: must be YYYY-MM", *month)
+		}
+	}
+	if *mailTo != "" && *mailFrom == "" {
+		return errors.New("-mail-from is required with -mail-to")
+	}
+
+	db, err := openHistory(*path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := monthHistory(db, start, *line)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("no history recorded for %s", start.Format("January 2006"))
+	}
+	reports := buildReports(rows, start)
+
+	var buf bytes.Buffer
+	if err := writeReport(&buf, start, reports); err != nil {
+		return err
+	}
+
+	if *mailTo != "" {
+		subject := "AAISP usage report for " + start.Format("January 2006")
+		return mailReport(*smtpAddr, *mailFrom, strings.Split(*mailTo, ","), subject, buf.Bytes())
+	}
+	if *out != "" {
+		return ioutil.WriteFile(*out, buf.Bytes(), 0644)
+	}
+	_, err = buf.WriteTo(os.Stdout)
+	return err
+}
+
+// monthHistory returns the recorded rows for the month beginning at start,
+// ordered by line and time.
+func monthHistory(db *sql.DB, start time.Time, f lineFilter) ([]historyRow, error) {
+	end := start.AddDate(0, 1, 0)
+	rs, err := db.Query(`SELECT time, line_id, login, quota_monthly, quota_remaining
+		FROM broadband WHERE time >= ? AND time < ? ORDER BY line_id, time`,
+		start.UTC().Format(historyTime), end.UTC().Format(historyTime))
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+
+	var rows []historyRow
+	for rs.Next() {
+		var (
+			r historyRow
+			t string
+		)
+		if err := rs.Scan(&t, &r.id, &r.login, &r.monthly, &r.remaining); err != nil {
+			return nil, err
+		}
+		if r.time, err = time.Parse(historyTime, t); err != nil {
+			return nil, err
+		}
+		r.time = r.time.In(start.Location())
+		if f.matches(r.id, r.login) {
+			rows = append(rows, r)
+		}
+	}
+	return rows, rs.Err()
+}
+
+// buildReports works out each line's usage from consecutive samples. A drop
+// in remaining quota is usage; a rise is a top-up.
+func buildReports(rows []historyRow, start time.Time) []lineReport {
+	var reports []lineReport
+	days := start.AddDate(0, 1, -1).Day()
+	for i := 0; i < len(rows); {
+		r := lineReport{ID: rows[i].id, Login: rows[i].login, Monthly: rows[i].monthly}
+		daily := make([]int, days)
+		last := 0
+		j := i + 1
+		for ; j < len(rows) && rows[j].id == r.ID; j++ {
+			prev, cur := rows[j-1], rows[j]
+			switch diff := prev.remaining - cur.remaining; {
+			case diff > 0:
+				daily[cur.time.Day()-1] += diff
+				r.Used += diff
+			case diff < 0:
+				r.TopUps = append(r.TopUps, topUp{Time: cur.time, Amount: -diff})
+			}
+			if cur.monthly > r.Monthly {
+				r.Monthly = cur.monthly
+			}
+			last = cur.time.Day()
+		}
+
+		cumulative := 0
+		for d := 0; d < last; d++ {
+			date := start.AddDate(0, 0, d)
+			cumulative += daily[d]
+			u := dayUsage{
+				Date:           date,
+				Used:           daily[d],
+				ElapsedPercent: 100 * monthElapsed(date.AddDate(0, 0, 1).Add(-time.Nanosecond)),
+			}
+			if r.Monthly > 0 {
+				u.UsedPercent = 100 * float64(cumulative) / float64(r.Monthly)
+			}
+			r.Days = append(r.Days, u)
+		}
+		reports = append(reports, r)
+		i = j
+	}
+	return reports
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+	"width": func(f float64) float64 {
+		if f > 100 {
+			return 100
+		}
+		return f
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>AAISP usage report for {{.Month.Format "January 2006"}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 0.2em 0.8em; text-align: right; border-bottom: 1px solid #ddd; }
+th:first-child, td:first-child { text-align: left; }
+.trend { width: 200px; }
+.track { position: relative; background: #eee; height: 0.8em; }
+.used { background: #4a90d9; height: 100%; }
+.elapsed { position: absolute; top: -0.2em; width: 2px; height: 1.2em; background: #222; }
+</style>
+</head>
+<body>
+<h1>AAISP usage report for {{.Month.Format "January 2006"}}</h1>
+{{- range .Lines}}
+<h2>Line {{.ID}}{{if .Login}} ({{.Login}}){{end}}</h2>
+<p>Used <strong>{{bytes .Used}}</strong>{{if .Monthly}} of a {{bytes .Monthly}} monthly quota{{end}}.</p>
+{{- if .TopUps}}
+<h3>Top-ups</h3>
+<table>
+<tr><th>Time</th><th>Amount</th></tr>
+{{- range .TopUps}}
+<tr><td>{{.Time.Format "Mon 2 Jan 15:04"}}</td><td>{{bytes .Amount}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+<h3>Daily usage</h3>
+<table>
+<tr><th>Date</th><th>Used</th><th>Quota used</th><th class="trend">Trend</th></tr>
+{{- range .Days}}
+<tr><td>{{.Date.Format "Mon 2 Jan"}}</td><td>{{bytes .Used}}</td><td>{{printf "%.0f%%" .UsedPercent}}</td>
+<td class="trend"><div class="track"><div class="used" style="width: {{printf "%.1f" (width .UsedPercent)}}%"></div><div class="elapsed" style="left: {{printf "%.1f" (width .ElapsedPercent)}}%"></div></div></td></tr>
+{{- end}}
+</table>
+{{- end}}
+<p>Usage is worked out from samples recorded by <code>chaos record</code>, so it's only as accurate as the recording interval.</p>
+</body>
+</html>
+`))
+
+func writeReport(w io.Writer, month time.Time, reports []lineReport) error {
+	return reportTemplate.Execute(w, struct {
+		Month time.Time
+		Lines []lineReport
+	}{month, reports})
+}
+
+// mailReport emails an HTML report. Credentials for SMTP authentication are
+// read from SMTP_USERNAME and SMTP_PASSWORD in the environment.
+func mailReport(addr, from string, to []string, subject string, html []byte) error {
+	for i := range to {
+		to[i] = strings.TrimSpace(to[i])
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/html; charset=utf-8\r\n\r\n")
+	msg.Write(bytes.ReplaceAll(html, []byte("\n"), []byte("\r\n")))
+
+	var auth smtp.Auth
+	if user := os.Getenv("SMTP_USERNAME"); user != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	return smtp.SendMail(addr, auth, from, to, msg.Bytes())
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+)
+
+// zabbixDiscoveryKey is the key of the low-level discovery rule. The item
+// prototypes use the keys in zabbixItems with {#LINEID} as their parameter.
+const zabbixDiscoveryKey = "aaisp.lines.discovery"
+
+var zabbixItems = []struct {
+	key   string
+	value func(chaos.BroadbandInfo) int
+}{
+	{"aaisp.quota.remaining", func(l chaos.BroadbandInfo) int { return l.QuotaRemaining }},
+	{"aaisp.quota.monthly", func(l chaos.BroadbandInfo) int { return l.QuotaMonthly }},
+	{"aaisp.rate.tx", func(l chaos.BroadbandInfo) int { return l.TXRate }},
+	{"aaisp.rate.rx", func(l chaos.BroadbandInfo) int { return l.RXRate }},
+}
+
+func runZabbix(args []string) error {
+	fs := newFlagSet("zabbix", "")
+	server := fs.String("server", "", "send discovery data and values to the Zabbix server or proxy at `address`, instead of printing discovery JSON")
+	host := fs.String("host", "", "Zabbix `host` name to send values for")
+	fs.Parse(args)
+
+	if *server != "" && *host == "" {
+		return errors.New("-host is required with -server")
+	}
+
+	api, err := newAPI()
+	if err != nil {
+		return err
+	}
+	lines, err := api.BroadbandInfo()
+	if err := warnPartial(err); err != nil {
+		return err
+	}
+
+	discovery, err := zabbixDiscovery(lines)
+	if err != nil {
+		return err
+	}
+	if *server == "" {
+		_, err := fmt.Printf("%s\n", discovery)
+		return err
+	}
+
+	if _, _, err := net.SplitHostPort(*server); err != nil {
+		*server = net.JoinHostPort(*server, "10051")
+	}
+	// Discovery has to be processed before values for new lines are accepted,
+	// so send it separately first.
+	info, err := zabbixSend(*server, []zabbixValue{{Host: *host, Key: zabbixDiscoveryKey, Value: string(discovery)}})
+	if err != nil {
+		return fmt.Errorf("sending discovery: %w", err)
+	}
+	fmt.Printf("discovery: %s\n", info)
+
+	var values []zabbixValue
+	for _, line := range lines {
+		for _, item := range zabbixItems {
+			values = append(values, zabbixValue{
+				Host:  *host,
+				Key:   item.key + "[" + strconv.Itoa(line.ID) + "]",
+				Value: strconv.Itoa(item.value(line)),
+			})
+		}
+	}
+	info, err = zabbixSend(*server, values)
+	if err != nil {
+		return fmt.Errorf("sending values: %w", err)
+	}
+	fmt.Printf("values: %s\n", info)
+	return nil
+}
+
+// zabbixDiscovery returns low-level discovery JSON for the lines.
+func zabbixDiscovery(lines []chaos.BroadbandInfo) ([]byte, error) {
+	data := make([]map[string]string, 0, len(lines))
+	for _, line := range lines {
+		data = append(data, map[string]string{
+			"{#LINEID}":   strconv.Itoa(line.ID),
+			"{#LOGIN}":    line.Login,
+			"{#POSTCODE}": line.Postcode,
+		})
+	}
+	return json.Marshal(struct {
+		Data []map[string]string `json:"data"`
+	}{data})
+}
+
+// zabbixValue is an item value sent using the Zabbix sender protocol.
+type zabbixValue struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// zabbixSend sends values to a Zabbix server or proxy's trapper, returning
+// the server's summary of how many were processed.
+func zabbixSend(addr string, values []zabbixValue) (string, error) {
+	body, err := json.Marshal(struct {
+		Request string        `json:"request"`
+		Data    []zabbixValue `json:"data"`
+	}{"sender data", values})
+	if err != nil {
+		return "", err
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	if _, err := conn.Write(zabbixPacket(body)); err != nil {
+		return "", err
+	}
+
+	resp, err := readZabbixPacket(conn)
+	if err != nil {
+		return "", err
+	}
+	var r struct {
+		Response string `json:"response"`
+		Info     string `json:"info"`
+	}
+	if err := json.Unmarshal(resp, &r); err != nil {
+		return "", fmt.Errorf("decoding response: %w", err)
+	}
+	if r.Response != "success" {
+		return "", fmt.Errorf("server responded %q: %s", r.Response, r.Info)
+	}
+	return r.Info, nil
+}
+
+var zabbixHeader = []byte("ZBXD\x01")
+
+// zabbixPacket frames data with the protocol header and length.
+func zabbixPacket(data []byte) []byte {
+	var b bytes.Buffer
+	b.Write(zabbixHeader)
+	binary.Write(&b, binary.LittleEndian, uint64(len(data)))
+	b.Write(data)
+	return b.Bytes()
+}
+
+func readZabbixPacket(r io.Reader) ([]byte, error) {
+	header := make([]byte, len(zabbixHeader)+8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:4], zabbixHeader[:4]) {
+		return nil, errors.New("invalid response header")
+	}
+	// The low 4 bytes are the data length; the high 4 are reserved.
+	n := binary.LittleEndian.Uint32(header[len(zabbixHeader):])
+	if n > 1<<20 {
+		return nil, fmt.Errorf("response too large (%d bytes)", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
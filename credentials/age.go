@@ -0,0 +1,71 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"filippo.io/age"
+	chaos "github.com/jamesog/aaisp-chaos"
+)
+
+// AgeFile reads credentials from a file encrypted with age
+// (https://age-encryption.org), so that configuration can be kept in a
+// repository without the credentials in plain text. The decrypted file has an
+// environment variable per line, as described for the exporter:
+//
+//	CHAOS_CONTROL_LOGIN=...
+//	CHAOS_CONTROL_PASSWORD=...
+type AgeFile struct {
+	Path string
+	// IdentityFile is an age identity file, such as one created by
+	// age-keygen, used to decrypt a file encrypted to its recipient.
+	IdentityFile string
+	// Passphrase returns the passphrase for a file encrypted with one. It's
+	// only used if IdentityFile isn't set.
+	Passphrase func() (string, error)
+}
+
+// Credentials implements chaos.CredentialProvider.
+func (f AgeFile) Credentials(ctx context.Context) (chaos.Auth, error) {
+	var ids []age.Identity
+	switch {
+	case f.IdentityFile != "":
+		k, err := os.Open(f.IdentityFile)
+		if err != nil {
+			return chaos.Auth{}, err
+		}
+		defer k.Close()
+		if ids, err = age.ParseIdentities(k); err != nil {
+			return chaos.Auth{}, fmt.Errorf("reading %s: %w", f.IdentityFile, err)
+		}
+	case f.Passphrase != nil:
+		pass, err := f.Passphrase()
+		if err != nil {
+			return chaos.Auth{}, err
+		}
+		id, err := age.NewScryptIdentity(pass)
+		if err != nil {
+			return chaos.Auth{}, err
+		}
+		ids = append(ids, id)
+	default:
+		return chaos.Auth{}, errors.New("an identity file or passphrase is needed to decrypt credentials")
+	}
+
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return chaos.Auth{}, err
+	}
+	defer file.Close()
+	r, err := age.Decrypt(file, ids...)
+	if err != nil {
+		return chaos.Auth{}, fmt.Errorf("decrypting %s: %w", f.Path, err)
+	}
+	auth, err := parseEnv(r)
+	if err != nil {
+		return auth, fmt.Errorf("%s: %w", f.Path, err)
+	}
+	return auth, nil
+}
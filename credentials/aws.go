@@ -0,0 +1,127 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+	"github.com/jamesog/aaisp-chaos/internal/sigv4"
+)
+
+// AWSSecretsManager reads credentials from an AWS Secrets Manager secret. The
+// secret's value is either a JSON object with the keys control_login and
+// control_password, or account_number and account_password, or environment
+// variables as described for the exporter.
+//
+// Requests are signed with AccessKeyID and SecretAccessKey if they're set.
+// Otherwise temporary credentials are fetched for the role of the ECS task or
+// EC2 instance the program is running on, and fetched again before they
+// expire.
+type AWSSecretsManager struct {
+	// Region is the AWS region the secret is in, e.g. "eu-west-2".
+	Region string
+	// SecretID is the secret's name or ARN.
+	SecretID string
+	// Endpoint is the Secrets Manager URL, e.g. for a VPC endpoint. The
+	// default is the region's public endpoint.
+	Endpoint string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// CacheFor is how long the secret is used before it's read again, which
+	// is how rotated credentials are picked up. The default is 5 minutes.
+	CacheFor time.Duration
+	// OnRefreshError, if set, is called when the secret can't be read again
+	// once it's been read, and the credentials read before are used
+	// instead.
+	OnRefreshError func(error)
+	// Client makes requests to AWS. If nil, a client with a 10 second timeout
+	// is used.
+	Client *http.Client
+
+	cache cache
+	once  sync.Once
+	keys  *sigv4.Source
+}
+
+// Credentials implements chaos.CredentialProvider.
+func (a *AWSSecretsManager) Credentials(ctx context.Context) (chaos.Auth, error) {
+	return a.cache.get(ctx, a.CacheFor, a.OnRefreshError, a.read)
+}
+
+func (a *AWSSecretsManager) read(ctx context.Context) (chaos.Auth, error) {
+	if a.Region == "" {
+		return chaos.Auth{}, errors.New("an AWS region is needed to read the secret")
+	}
+	client := a.Client
+	if client == nil {
+		client = defaultClient
+	}
+	a.once.Do(func() {
+		a.keys = &sigv4.Source{
+			AccessKeyID:     a.AccessKeyID,
+			SecretAccessKey: a.SecretAccessKey,
+			SessionToken:    a.SessionToken,
+			Client:          client,
+		}
+	})
+	keys, err := a.keys.Keys(ctx)
+	if err != nil {
+		return chaos.Auth{}, fmt.Errorf("getting AWS credentials: %w", err)
+	}
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + a.Region + ".amazonaws.com/"
+	}
+	body, err := json.Marshal(map[string]string{"SecretId": a.SecretID})
+	if err != nil {
+		return chaos.Auth{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return chaos.Auth{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	sigv4.Sign(req, body, keys, a.Region, "secretsmanager", time.Now())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return chaos.Auth{}, fmt.Errorf("reading AWS secret %s: %w", a.SecretID, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return chaos.Auth{}, fmt.Errorf("reading AWS secret %s: %w", a.SecretID, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(b, &e) == nil && e.Type != "" {
+			return chaos.Auth{}, fmt.Errorf("reading AWS secret %s: %s: %s: %s", a.SecretID, resp.Status, e.Type, e.Message)
+		}
+		return chaos.Auth{}, fmt.Errorf("reading AWS secret %s: %s", a.SecretID, resp.Status)
+	}
+	var secret struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(b, &secret); err != nil {
+		return chaos.Auth{}, fmt.Errorf("reading AWS secret %s: %w", a.SecretID, err)
+	}
+	auth, err := parseSecret([]byte(secret.SecretString))
+	if err != nil {
+		return auth, fmt.Errorf("AWS secret %s: %w", a.SecretID, err)
+	}
+	return auth, nil
+}
@@ -0,0 +1,144 @@
+// Package credentials provides sources of CHAOS API credentials, implementing
+// chaos.CredentialProvider.
+package credentials
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+)
+
+// parseEnv reads credentials from lines of environment variables, using the
+// same names as the exporter and command line client:
+//
+//	CHAOS_CONTROL_LOGIN=...
+//	CHAOS_CONTROL_PASSWORD=...
+//
+// CHAOS_ACCOUNT_NUMBER and CHAOS_ACCOUNT_PASSWORD are also read. Blank lines
+// and lines starting with # are ignored, and values may be quoted.
+func parseEnv(r io.Reader) (chaos.Auth, error) {
+	var auth chaos.Auth
+	fields := map[string]*string{
+		"CHAOS_CONTROL_LOGIN":    &auth.ControlLogin,
+		"CHAOS_CONTROL_PASSWORD": &auth.ControlPassword,
+		"CHAOS_ACCOUNT_NUMBER":   &auth.AccountNumber,
+		"CHAOS_ACCOUNT_PASSWORD": &auth.AccountPassword,
+	}
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			return auth, fmt.Errorf("line %d: expected NAME=value", n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if f, ok := fields[strings.TrimSpace(name)]; ok {
+			*f = value
+		}
+	}
+	if err := s.Err(); err != nil {
+		return auth, err
+	}
+	return auth, check(auth)
+}
+
+// check returns an error if auth doesn't have a complete set of credentials.
+func check(auth chaos.Auth) error {
+	if (auth.ControlLogin == "" || auth.ControlPassword == "") && (auth.AccountNumber == "" || auth.AccountPassword == "") {
+		return fmt.Errorf("no control login and password, or account number and password")
+	}
+	return nil
+}
+
+// parseSecret reads credentials from a secret's value, which is either a JSON
+// object with the same keys as a Vault secret, or environment variables as
+// read by parseEnv.
+func parseSecret(b []byte) (chaos.Auth, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || b[0] != '{' {
+		return parseEnv(bytes.NewReader(b))
+	}
+	var d map[string]string
+	if err := json.Unmarshal(b, &d); err != nil {
+		return chaos.Auth{}, err
+	}
+	return secretAuth(d)
+}
+
+// secretAuth reads credentials from a secret's keys: control_login and
+// control_password, or account_number and account_password.
+func secretAuth(d map[string]string) (chaos.Auth, error) {
+	auth := chaos.Auth{
+		ControlLogin:    d["control_login"],
+		ControlPassword: d["control_password"],
+		AccountNumber:   d["account_number"],
+		AccountPassword: d["account_password"],
+	}
+	return auth, check(auth)
+}
+
+// defaultClient makes requests to secret stores for providers without a
+// Client, with a timeout so that a store which stops responding can't block
+// requests to the API indefinitely.
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// retryStaleAfter is how long cached credentials are used after the secret
+// store couldn't be read, before it's tried again.
+const retryStaleAfter = 30 * time.Second
+
+// cache holds credentials read from a secret store until they're due to be
+// read again.
+type cache struct {
+	mu      sync.Mutex
+	auth    chaos.Auth
+	readAt  time.Time
+	retryAt time.Time
+}
+
+// get returns the cached credentials, or calls read if they're older than
+// cacheFor, or 5 minutes if it's zero.
+//
+// If read fails, the credentials read before are still the best available,
+// since the secret store being down doesn't make them invalid, so they're
+// returned and the error is passed to onErr, if it's set. The store is tried
+// again after retryStaleAfter. An error is only returned if nothing has been
+// read yet.
+func (c *cache) get(ctx context.Context, cacheFor time.Duration, onErr func(error), read func(context.Context) (chaos.Auth, error)) (chaos.Auth, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cacheFor <= 0 {
+		cacheFor = 5 * time.Minute
+	}
+	now := time.Now()
+	if !c.readAt.IsZero() && (now.Sub(c.readAt) < cacheFor || now.Before(c.retryAt)) {
+		return c.auth, nil
+	}
+	auth, err := read(ctx)
+	if err != nil {
+		if c.readAt.IsZero() {
+			return auth, err
+		}
+		if onErr != nil {
+			onErr(err)
+		}
+		c.retryAt = now.Add(retryStaleAfter)
+		return c.auth, nil
+	}
+	c.auth, c.readAt = auth, now
+	return auth, nil
+}
@@ -0,0 +1,60 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+)
+
+func TestCacheKeepsCredentialsWhenReadFails(t *testing.T) {
+	good := chaos.Auth{ControlLogin: "login", ControlPassword: "password"}
+	down := errors.New("secret store is down")
+	var (
+		c        cache
+		readErr  error
+		reads    int
+		reported []error
+	)
+	read := func(context.Context) (chaos.Auth, error) {
+		reads++
+		if readErr != nil {
+			return chaos.Auth{}, readErr
+		}
+		return good, nil
+	}
+	onErr := func(err error) { reported = append(reported, err) }
+	get := func() (chaos.Auth, error) {
+		return c.get(context.Background(), time.Nanosecond, onErr, read)
+	}
+
+	readErr = down
+	if _, err := get(); !errors.Is(err, down) {
+		t.Fatalf("first read: got error %v, want %v", err, down)
+	}
+
+	readErr = nil
+	if auth, err := get(); err != nil || auth != good {
+		t.Fatalf("got %v, %v; want the credentials", auth, err)
+	}
+
+	readErr = down
+	auth, err := get()
+	if err != nil || auth != good {
+		t.Fatalf("failed refresh: got %v, %v; want the previous credentials", auth, err)
+	}
+	if len(reported) != 1 || !errors.Is(reported[0], down) {
+		t.Errorf("refresh errors reported: %v", reported)
+	}
+
+	// The store isn't tried again until retryStaleAfter.
+	n := reads
+	if auth, err := get(); err != nil || auth != good {
+		t.Fatalf("got %v, %v; want the previous credentials", auth, err)
+	}
+	if reads != n {
+		t.Errorf("secret store read again %d times within retryStaleAfter", reads-n)
+	}
+}
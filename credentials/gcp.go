@@ -0,0 +1,157 @@
+package credentials
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+)
+
+// GCPSecretManager reads credentials from a Google Cloud Secret Manager
+// secret, in the same formats as AWSSecretsManager.
+//
+// Requests are authorized with Token if it's set. Otherwise an access token
+// for the service account of the Compute Engine instance, GKE pod or Cloud Run
+// service the program is running on is fetched from the metadata server, and
+// fetched again before it expires.
+type GCPSecretManager struct {
+	// Secret is the secret's resource name, e.g.
+	// "projects/my-project/secrets/aaisp". A version may be given, e.g.
+	// "projects/my-project/secrets/aaisp/versions/3"; the default is the
+	// latest version.
+	Secret string
+	// Token is an OAuth 2.0 access token, e.g. from
+	// "gcloud auth print-access-token". It isn't refreshed, and access
+	// tokens usually expire after an hour, so it's only suitable for short
+	// runs. Long-running programs should leave it empty and use the
+	// metadata server.
+	Token string
+
+	// CacheFor is how long the secret is used before it's read again, which
+	// is how rotated credentials are picked up. The default is 5 minutes.
+	CacheFor time.Duration
+	// OnRefreshError, if set, is called when the secret can't be read again
+	// once it's been read, and the credentials read before are used
+	// instead.
+	OnRefreshError func(error)
+	// Client makes requests to Google Cloud. If nil, a client with a 10
+	// second timeout is used.
+	Client *http.Client
+
+	cache   cache
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Credentials implements chaos.CredentialProvider.
+func (g *GCPSecretManager) Credentials(ctx context.Context) (chaos.Auth, error) {
+	return g.cache.get(ctx, g.CacheFor, g.OnRefreshError, g.read)
+}
+
+func (g *GCPSecretManager) read(ctx context.Context) (chaos.Auth, error) {
+	name := strings.Trim(g.Secret, "/")
+	if !strings.HasPrefix(name, "projects/") || !strings.Contains(name, "/secrets/") {
+		return chaos.Auth{}, fmt.Errorf("invalid secret name %q: must be projects/PROJECT/secrets/SECRET", g.Secret)
+	}
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	token, err := g.accessToken(ctx)
+	if err != nil {
+		return chaos.Auth{}, fmt.Errorf("getting a Google Cloud access token: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://secretmanager.googleapis.com/v1/"+name+":access", nil)
+	if err != nil {
+		return chaos.Auth{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	var secret struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := g.getJSON(req, &secret); err != nil {
+		return chaos.Auth{}, fmt.Errorf("reading Google Cloud secret %s: %w", g.Secret, err)
+	}
+	b, err := base64.StdEncoding.DecodeString(secret.Payload.Data)
+	if err != nil {
+		return chaos.Auth{}, fmt.Errorf("reading Google Cloud secret %s: %w", g.Secret, err)
+	}
+	auth, err := parseSecret(b)
+	if err != nil {
+		return auth, fmt.Errorf("Google Cloud secret %s: %w", g.Secret, err)
+	}
+	return auth, nil
+}
+
+// accessToken returns Token, or the service account's token from the
+// metadata server, fetching a new one when it's within 5 minutes of
+// expiring.
+func (g *GCPSecretManager) accessToken(ctx context.Context) (string, error) {
+	if g.Token != "" {
+		return g.Token, nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.token != "" && time.Until(g.expires) > 5*time.Minute {
+		return g.token, nil
+	}
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var t struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := g.getJSON(req, &t); err != nil {
+		return "", fmt.Errorf("no access token configured, and the metadata server isn't available: %w", err)
+	}
+	g.token = t.AccessToken
+	g.expires = time.Now().Add(time.Duration(t.ExpiresIn) * time.Second)
+	return g.token, nil
+}
+
+func (g *GCPSecretManager) getJSON(req *http.Request, v interface{}) error {
+	client := g.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(b, &e) == nil && e.Error.Message != "" {
+			return fmt.Errorf("%s: %s", resp.Status, e.Error.Message)
+		}
+		return errors.New(resp.Status)
+	}
+	return json.Unmarshal(b, v)
+}
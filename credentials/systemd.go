@@ -0,0 +1,60 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+)
+
+// Systemd reads credentials passed to a service by systemd with
+// LoadCredential= or LoadCredentialEncrypted=, which systemd makes
+// readable only by the service, in a directory given by the
+// CREDENTIALS_DIRECTORY environment variable. Each credential is a file named
+// after its environment variable in lower case:
+//
+//	LoadCredential=chaos_control_login:/etc/aaisp/control_login
+//	LoadCredentialEncrypted=chaos_control_password:/etc/aaisp/control_password.cred
+//
+// chaos_account_number and chaos_account_password are also read.
+type Systemd struct {
+	// Dir is the credentials directory. The default is
+	// $CREDENTIALS_DIRECTORY.
+	Dir string
+}
+
+// Credentials implements chaos.CredentialProvider.
+func (s Systemd) Credentials(ctx context.Context) (chaos.Auth, error) {
+	dir := s.Dir
+	if dir == "" {
+		dir = os.Getenv("CREDENTIALS_DIRECTORY")
+	}
+	if dir == "" {
+		return chaos.Auth{}, errors.New("CREDENTIALS_DIRECTORY isn't set; the service needs LoadCredential= settings")
+	}
+	var auth chaos.Auth
+	for name, f := range map[string]*string{
+		"chaos_control_login":    &auth.ControlLogin,
+		"chaos_control_password": &auth.ControlPassword,
+		"chaos_account_number":   &auth.AccountNumber,
+		"chaos_account_password": &auth.AccountPassword,
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return chaos.Auth{}, err
+		}
+		*f = strings.TrimRight(string(b), "\r\n")
+	}
+	if err := check(auth); err != nil {
+		return auth, fmt.Errorf("systemd credentials: %w", err)
+	}
+	return auth, nil
+}
@@ -0,0 +1,190 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+)
+
+// Vault reads credentials from a HashiCorp Vault KV version 2 secret, with
+// the keys control_login and control_password, or account_number and
+// account_password.
+//
+// Vault authenticates with Token, or else by logging in with AppRole. Tokens
+// from AppRole are renewed when they're halfway to expiring, and replaced by
+// logging in again if they can't be renewed.
+type Vault struct {
+	// Address is Vault's URL, e.g. "https://vault.example.com:8200".
+	Address string
+	// Namespace is the Vault Enterprise namespace, if any.
+	Namespace string
+	// Mount is the path the KV secrets engine is mounted at. The default is
+	// "secret".
+	Mount string
+	// Path is the secret's path within the mount, e.g. "aaisp/exporter".
+	Path string
+
+	// Token is a Vault token. If it's empty, RoleID and SecretID are used to
+	// log in with AppRole.
+	Token    string
+	RoleID   string
+	SecretID string
+	// AppRoleMount is the path the AppRole auth method is mounted at. The
+	// default is "approle".
+	AppRoleMount string
+
+	// CacheFor is how long the secret is used before it's read again, which
+	// is how rotated credentials are picked up. The default is 5 minutes.
+	CacheFor time.Duration
+	// OnRefreshError, if set, is called when the secret can't be read again
+	// once it's been read, and the credentials read before are used
+	// instead.
+	OnRefreshError func(error)
+	// Client makes requests to Vault. If nil, a client with a 10 second
+	// timeout is used.
+	Client *http.Client
+
+	cache cache
+	// The token is only used by read, which the cache doesn't call
+	// concurrently.
+	token     string
+	renewable bool
+	renewAt   time.Time
+	expires   time.Time
+}
+
+// Credentials implements chaos.CredentialProvider.
+func (v *Vault) Credentials(ctx context.Context) (chaos.Auth, error) {
+	return v.cache.get(ctx, v.CacheFor, v.OnRefreshError, v.read)
+}
+
+func (v *Vault) read(ctx context.Context) (chaos.Auth, error) {
+	token, err := v.currentToken(ctx)
+	if err != nil {
+		return chaos.Auth{}, err
+	}
+	mount := v.Mount
+	if mount == "" {
+		mount = "secret"
+	}
+	var secret struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	path := "/v1/" + strings.Trim(mount, "/") + "/data/" + strings.Trim(v.Path, "/")
+	if err := v.do(ctx, http.MethodGet, path, token, nil, &secret); err != nil {
+		return chaos.Auth{}, fmt.Errorf("reading Vault secret %s: %w", v.Path, err)
+	}
+	auth, err := secretAuth(secret.Data.Data)
+	if err != nil {
+		return auth, fmt.Errorf("Vault secret %s: %w", v.Path, err)
+	}
+	return auth, nil
+}
+
+// vaultAuth is the auth section of a login or renewal response.
+type vaultAuth struct {
+	Auth struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+}
+
+// currentToken returns a token to read the secret with, logging in or
+// renewing the token as needed.
+func (v *Vault) currentToken(ctx context.Context) (string, error) {
+	if v.Token != "" {
+		return v.Token, nil
+	}
+	now := time.Now()
+	if v.token != "" && now.Before(v.renewAt) {
+		return v.token, nil
+	}
+	if v.token != "" && v.renewable && now.Before(v.expires) {
+		var a vaultAuth
+		if err := v.do(ctx, http.MethodPost, "/v1/auth/token/renew-self", v.token, struct{}{}, &a); err == nil {
+			v.setToken(a)
+			return v.token, nil
+		}
+		// Log in again below.
+	}
+
+	if v.RoleID == "" || v.SecretID == "" {
+		return "", errors.New("a Vault token, or AppRole role ID and secret ID, are needed")
+	}
+	mount := v.AppRoleMount
+	if mount == "" {
+		mount = "approle"
+	}
+	body := map[string]string{"role_id": v.RoleID, "secret_id": v.SecretID}
+	var a vaultAuth
+	if err := v.do(ctx, http.MethodPost, "/v1/auth/"+strings.Trim(mount, "/")+"/login", "", body, &a); err != nil {
+		return "", fmt.Errorf("logging in to Vault with AppRole: %w", err)
+	}
+	v.setToken(a)
+	return v.token, nil
+}
+
+func (v *Vault) setToken(a vaultAuth) {
+	ttl := time.Duration(a.Auth.LeaseDuration) * time.Second
+	now := time.Now()
+	v.token = a.Auth.ClientToken
+	v.renewable = a.Auth.Renewable
+	v.expires = now.Add(ttl)
+	v.renewAt = now.Add(ttl / 2)
+	if ttl == 0 {
+		// The token doesn't expire.
+		v.renewAt = now.Add(100 * 365 * 24 * time.Hour)
+	}
+}
+
+// do makes a request to the Vault API, decoding the response into v.
+func (v *Vault) do(ctx context.Context, method, path, token string, body, resp interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(v.Address, "/")+path, r)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	client := v.Client
+	if client == nil {
+		client = defaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		if json.NewDecoder(res.Body).Decode(&e) == nil && len(e.Errors) > 0 {
+			return fmt.Errorf("%s: %s", res.Status, strings.Join(e.Errors, "; "))
+		}
+		return errors.New(res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(resp)
+}
@@ -0,0 +1,216 @@
+package chaos
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// DecodeWarning describes a field in an API response which couldn't be
+// decoded. Rather than failing the whole response, the field is left as its
+// zero value.
+type DecodeWarning struct {
+	// Field is the field's JSON name, e.g. "quota_remaining".
+	Field string
+	// Value is the field's raw JSON value.
+	Value string
+	Err   error
+}
+
+func (w DecodeWarning) Error() string {
+	return fmt.Sprintf("decoding %s %s: %v", w.Field, w.Value, w.Err)
+}
+
+// warnFunc is called for each field which couldn't be decoded. It may be nil.
+type warnFunc func(DecodeWarning)
+
+func (f warnFunc) warn(field string, raw []byte, err error) {
+	if f != nil {
+		f(DecodeWarning{Field: field, Value: string(raw), Err: err})
+	}
+}
+
+// lenientInt decodes an integer given as either a JSON number or a string.
+// Nulls, empty strings and missing fields decode as zero. Anything else which
+// isn't an integer is kept to be reported as a warning.
+type lenientInt struct {
+	n   int
+	raw json.RawMessage
+	err error
+}
+
+func (i *lenientInt) UnmarshalJSON(b []byte) error {
+	s := string(bytes.Trim(b, `"`))
+	if s == "" || s == "null" {
+		return nil
+	}
+	n, err := strconv.ParseInt(s, 10, 0)
+	if err != nil {
+		// Accept numbers with a fractional part or an exponent, e.g.
+		// 1e+12, as some encoders produce them for large values.
+		f, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil {
+			i.raw, i.err = append(json.RawMessage(nil), b...), err
+			return nil
+		}
+		n = int64(f)
+	}
+	i.n = int(n)
+	return nil
+}
+
+func (i lenientInt) value(field string, warn warnFunc) int {
+	if i.err != nil {
+		warn.warn(field, i.raw, i.err)
+	}
+	return i.n
+}
+
+// lenientTime decodes a Time, keeping any error to be reported as a warning.
+type lenientTime struct {
+	t   Time
+	raw json.RawMessage
+	err error
+}
+
+func (t *lenientTime) UnmarshalJSON(b []byte) error {
+	if err := t.t.UnmarshalJSON(b); err != nil {
+		t.raw, t.err = append(json.RawMessage(nil), b...), err
+	}
+	return nil
+}
+
+func (t lenientTime) value(field string, warn warnFunc) Time {
+	if t.err != nil {
+		warn.warn(field, t.raw, t.err)
+	}
+	return t.t
+}
+
+// broadbandInfoJSON is the API's encoding of a BroadbandInfo.
+type broadbandInfoJSON struct {
+	ID             lenientInt  `json:"id"`
+	Login          string      `json:"login"`
+	Postcode       string      `json:"postcode"`
+	TXRate         lenientInt  `json:"tx_rate"`
+	RXRate         lenientInt  `json:"rx_rate"`
+	TXRateAdjusted lenientInt  `json:"tx_rate_adjusted"`
+	QuotaMonthly   lenientInt  `json:"quota_monthly"`
+	QuotaRemaining lenientInt  `json:"quota_remaining"`
+	QuotaTimestamp lenientTime `json:"quota_timestamp"`
+}
+
+func (r broadbandInfoJSON) value(warn warnFunc) BroadbandInfo {
+	return BroadbandInfo{
+		ID:             r.ID.value("id", warn),
+		Login:          r.Login,
+		Postcode:       r.Postcode,
+		TXRate:         r.TXRate.value("tx_rate", warn),
+		RXRate:         r.RXRate.value("rx_rate", warn),
+		TXRateAdjusted: r.TXRateAdjusted.value("tx_rate_adjusted", warn),
+		QuotaMonthly:   r.QuotaMonthly.value("quota_monthly", warn),
+		QuotaRemaining: r.QuotaRemaining.value("quota_remaining", warn),
+		QuotaTimestamp: r.QuotaTimestamp.value("quota_timestamp", warn),
+	}
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Fields which can't be decoded
+// are left as their zero value.
+func (b *BroadbandInfo) UnmarshalJSON(data []byte) error {
+	var r broadbandInfoJSON
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	*b = r.value(nil)
+	return nil
+}
+
+// broadbandQuotaJSON is the API's encoding of a BroadbandQuota.
+type broadbandQuotaJSON struct {
+	ID             lenientInt  `json:"id"`
+	QuotaMonthly   lenientInt  `json:"quota_monthly"`
+	QuotaRemaining lenientInt  `json:"quota_remaining"`
+	QuotaTimestamp lenientTime `json:"quota_timestamp"`
+}
+
+func (r broadbandQuotaJSON) value(warn warnFunc) BroadbandQuota {
+	return BroadbandQuota{
+		ID:             r.ID.value("id", warn),
+		QuotaMonthly:   r.QuotaMonthly.value("quota_monthly", warn),
+		QuotaRemaining: r.QuotaRemaining.value("quota_remaining", warn),
+		QuotaTimestamp: r.QuotaTimestamp.value("quota_timestamp", warn),
+	}
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Fields which can't be decoded
+// are left as their zero value.
+func (q *BroadbandQuota) UnmarshalJSON(data []byte) error {
+	var r broadbandQuotaJSON
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	*q = r.value(nil)
+	return nil
+}
+
+// LineError is an error decoding one line in a response.
+type LineError struct {
+	// Index is the line's position in the response, from zero.
+	Index int
+	// ID is the line's ID, or zero if it couldn't be decoded either.
+	ID  int
+	Err error
+}
+
+func (e *LineError) Error() string {
+	if e.ID != 0 {
+		return fmt.Sprintf("decoding line %d: %v", e.ID, e.Err)
+	}
+	return fmt.Sprintf("decoding line at index %d: %v", e.Index, e.Err)
+}
+
+func (e *LineError) Unwrap() error { return e.Err }
+
+// PartialError is returned along with the lines which could be decoded when
+// others in the same response couldn't, so that one corrupt line doesn't hide
+// the rest. Errs has a *LineError for each line which was left out.
+type PartialError struct {
+	Errs []error
+}
+
+func (e *PartialError) Error() string {
+	if len(e.Errs) == 1 {
+		return e.Errs[0].Error()
+	}
+	return fmt.Sprintf("%v (and %d more lines)", e.Errs[0], len(e.Errs)-1)
+}
+
+func (e *PartialError) Unwrap() []error { return e.Errs }
+
+// decodeLines decodes each line in raw and converts it to its exported type,
+// reporting any fields which couldn't be decoded to warn. Lines which can't be
+// decoded at all are left out, and described by a PartialError.
+func decodeLines[R interface{ value(warnFunc) T }, T any](raw []json.RawMessage, warn warnFunc) ([]T, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	vs := make([]T, 0, len(raw))
+	var errs []error
+	for i, b := range raw {
+		var r R
+		if err := json.Unmarshal(b, &r); err != nil {
+			var id struct {
+				ID lenientInt `json:"id"`
+			}
+			json.Unmarshal(b, &id)
+			errs = append(errs, &LineError{Index: i, ID: id.ID.n, Err: err})
+			continue
+		}
+		vs = append(vs, r.value(warn))
+	}
+	if errs != nil {
+		return vs, &PartialError{Errs: errs}
+	}
+	return vs, nil
+}
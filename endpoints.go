@@ -0,0 +1,90 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Endpoint is an API command, with the parameters it requires besides the
+// authentication credentials.
+type Endpoint struct {
+	Path     string
+	Required []string
+}
+
+// Known endpoints.
+var (
+	BroadbandInfoEndpoint  = Endpoint{Path: "/broadband/info"}
+	BroadbandQuotaEndpoint = Endpoint{Path: "/broadband/quota"}
+)
+
+// Endpoints lists the known endpoints. None of them change anything, so
+// requests to them are safe to repeat.
+var Endpoints = []Endpoint{
+	BroadbandInfoEndpoint,
+	BroadbandQuotaEndpoint,
+}
+
+// ErrReadOnly is returned for a request to an endpoint which isn't known, and
+// so might change something, when API.ReadOnly is set.
+var ErrReadOnly = errors.New("refusing to call an endpoint which might make changes in read-only mode")
+
+// LookupEndpoint returns the known endpoint with the given path.
+func LookupEndpoint(path string) (Endpoint, bool) {
+	for _, e := range Endpoints {
+		if e.Path == path {
+			return e, true
+		}
+	}
+	return Endpoint{}, false
+}
+
+// URL returns the endpoint's URL on the API at base, such as
+// "https://chaos2.aa.net.uk".
+func (e Endpoint) URL(base string) string {
+	return strings.TrimSuffix(base, "/") + e.Path
+}
+
+// Validate returns an error if params is missing any of the endpoint's
+// required parameters.
+func (e Endpoint) Validate(params url.Values) error {
+	var missing []string
+	for _, p := range e.Required {
+		if params.Get(p) == "" {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s: missing required parameters: %s", e.Path, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Params builds parameters for the endpoint from alternating keys and values,
+// and validates them.
+func (e Endpoint) Params(kv ...string) (url.Values, error) {
+	if len(kv)%2 != 0 {
+		return nil, fmt.Errorf("%s: odd number of parameter keys and values", e.Path)
+	}
+	params := url.Values{}
+	for i := 0; i < len(kv); i += 2 {
+		params.Add(kv[i], kv[i+1])
+	}
+	return params, e.Validate(params)
+}
+
+// Call validates params and calls the endpoint with Do.
+func (api API) Call(e Endpoint, params url.Values) ([]byte, error) {
+	return api.CallContext(context.Background(), e, params)
+}
+
+// CallContext is like Call, but gives up when ctx is done.
+func (api API) CallContext(ctx context.Context, e Endpoint, params url.Values) ([]byte, error) {
+	if err := e.Validate(params); err != nil {
+		return nil, err
+	}
+	return api.DoContext(ctx, e.Path, params)
+}
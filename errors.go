@@ -0,0 +1,59 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// APIError is an error from a request to the API.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response. It's zero if
+	// there was no response, or if the API returned an error message in a
+	// successful response.
+	StatusCode int
+	// Message is the error message returned by the API, if any.
+	Message string
+	// Err is the underlying error, if there was no response or it couldn't
+	// be read.
+	Err error
+}
+
+func (e *APIError) Error() string {
+	switch {
+	case e.Message != "":
+		return e.Message
+	case e.Err != nil:
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("bad response code: %d", e.StatusCode)
+}
+
+func (e *APIError) Unwrap() error { return e.Err }
+
+// Retryable reports whether the request might succeed if it's repeated. Network
+// errors, timeouts and server errors are retryable; error messages from the
+// API, such as for invalid credentials or parameters, aren't, and nor is a
+// request cancelled by its context.
+func (e *APIError) Retryable() bool {
+	switch {
+	case e.Message != "":
+		return false
+	case e.StatusCode >= 500, e.StatusCode == http.StatusTooManyRequests:
+		return true
+	case e.StatusCode != 0:
+		return false
+	}
+	return e.Err != nil && !errors.Is(e.Err, context.Canceled)
+}
+
+// Temporary is the same as Retryable, for callers checking for the
+// interface{ Temporary() bool } convention.
+func (e *APIError) Temporary() bool { return e.Retryable() }
+
+// IsRetryable reports whether err is an APIError which is retryable.
+func IsRetryable(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Retryable()
+}
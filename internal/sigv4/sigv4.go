@@ -0,0 +1,279 @@
+// Package sigv4 signs requests to AWS with Signature Version 4, and finds the
+// keys to sign them with.
+package sigv4
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keys are credentials for signing requests.
+type Keys struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// Source provides the keys to sign requests with. The configured keys are
+// used if they're set. Otherwise temporary keys are fetched for the role of
+// the ECS task or EC2 instance the program is running on, and fetched again
+// before they expire.
+type Source struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Client makes requests for role keys. If nil, http.DefaultClient is
+	// used.
+	Client *http.Client
+
+	mu   sync.Mutex
+	keys Keys
+}
+
+// Keys returns the configured keys, or temporary keys for the container's or
+// instance's role, fetching new ones when they're within 5 minutes of
+// expiring.
+func (s *Source) Keys(ctx context.Context) (Keys, error) {
+	if s.AccessKeyID != "" {
+		return Keys{AccessKeyID: s.AccessKeyID, SecretAccessKey: s.SecretAccessKey, Token: s.SessionToken}, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys.AccessKeyID != "" && time.Until(s.keys.Expiration) > 5*time.Minute {
+		return s.keys, nil
+	}
+	var (
+		keys Keys
+		err  error
+	)
+	if os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" || os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "" {
+		keys, err = s.containerKeys(ctx)
+	} else {
+		keys, err = s.instanceKeys(ctx)
+	}
+	if err != nil {
+		return keys, err
+	}
+	s.keys = keys
+	return keys, nil
+}
+
+// containerKeys fetches the ECS task role's keys from the container
+// credentials endpoint.
+func (s *Source) containerKeys(ctx context.Context) (Keys, error) {
+	u := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if rel := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); rel != "" {
+		u = "http://169.254.170.2" + rel
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return Keys{}, err
+	}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	var keys Keys
+	return keys, s.getJSON(req, &keys)
+}
+
+// instanceKeys fetches the EC2 instance role's keys from the instance
+// metadata service, using IMDSv2.
+func (s *Source) instanceKeys(ctx context.Context) (Keys, error) {
+	const imds = "http://169.254.169.254/latest"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, imds+"/api/token", nil)
+	if err != nil {
+		return Keys{}, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "300")
+	token, err := s.getText(req)
+	if err != nil {
+		return Keys{}, fmt.Errorf("no AWS keys configured, and the instance metadata service isn't available: %w", err)
+	}
+
+	path := imds + "/meta-data/iam/security-credentials/"
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, path, nil); err != nil {
+		return Keys{}, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token", token)
+	role, err := s.getText(req)
+	if err != nil {
+		return Keys{}, fmt.Errorf("finding the instance's role: %w", err)
+	}
+	role, _, _ = strings.Cut(role, "\n")
+
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, path+role, nil); err != nil {
+		return Keys{}, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token", token)
+	var keys Keys
+	return keys, s.getJSON(req, &keys)
+}
+
+func (s *Source) getText(req *http.Request) (string, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(resp.Status)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (s *Source) getJSON(req *http.Request, v interface{}) error {
+	text, err := s.getText(req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(text), v)
+}
+
+// Sign adds Signature Version 4 headers to req, whose body is body. The Host
+// header is signed, along with Content-Type if it's set and every X-Amz-
+// header.
+func Sign(req *http.Request, body []byte, keys Keys, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if keys.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", keys.Token)
+	}
+
+	headers := []string{"host"}
+	if req.Header.Get("Content-Type") != "" {
+		headers = append(headers, "content-type")
+	}
+	for h := range req.Header {
+		if h := strings.ToLower(h); strings.HasPrefix(h, "x-amz-") {
+			headers = append(headers, h)
+		}
+	}
+	sort.Strings(headers)
+	var canonicalHeaders strings.Builder
+	for _, h := range headers {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.RawQuery),
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+keys.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		keys.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns the query string with its parameters sorted by name
+// and then value, and encoded as Signature Version 4 requires.
+func canonicalQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	var params [][2]string
+	for _, p := range strings.Split(rawQuery, "&") {
+		if p == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(p, "=")
+		if uk, err := url.QueryUnescape(k); err == nil {
+			k = uk
+		}
+		if uv, err := url.QueryUnescape(v); err == nil {
+			v = uv
+		}
+		params = append(params, [2]string{uriEncode(k), uriEncode(v)})
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+	pairs := make([]string, len(params))
+	for i, p := range params {
+		pairs[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes every byte of s except the unreserved characters,
+// using upper-case hex digits.
+func uriEncode(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0xF])
+	}
+	return b.String()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
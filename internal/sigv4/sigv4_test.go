@@ -0,0 +1,61 @@
+package sigv4
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// The requests and signatures are from the AWS Signature Version 4 test
+// suite, which signs with these keys at this time.
+var (
+	suiteKeys = Keys{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	suiteTime = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+)
+
+func TestSignTestSuite(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       string
+		signature string
+	}{
+		{"get-vanilla", "https://example.amazonaws.com/", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-vanilla-query-order-key-case", "https://example.amazonaws.com/?Param2=value2&Param1=value1", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			Sign(req, nil, suiteKeys, "us-east-1", "service", suiteTime)
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=host;x-amz-date, Signature=" + tt.signature
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("Authorization = %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestCanonicalQuery(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"", ""},
+		{"b=2&a=1", "a=1&b=2"},
+		{"a=2&a=1", "a=1&a=2"},
+		// Names are sorted as names, not as name=value strings.
+		{"a1=x&a=y", "a=y&a1=x"},
+		{"a", "a="},
+		{"k=a+b", "k=a%20b"},
+		{"k=a%2fb", "k=a%2Fb"},
+		{"k=-_.~!*", "k=-_.~%21%2A"},
+		{"k=%E2%9C%93", "k=%E2%9C%93"},
+	}
+	for _, tt := range tests {
+		if got := canonicalQuery(tt.raw); got != tt.want {
+			t.Errorf("canonicalQuery(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"iter"
+)
+
+// LinesSeq returns an iterator over the broadband lines, for ranging over
+// with their errors:
+//
+//	for line, err := range api.LinesSeq(ctx) {
+//		...
+//	}
+//
+// The API returns every line in a single response, which is fetched when
+// iteration starts. If the request fails, its error is yielded once. Lines
+// which couldn't be decoded are yielded as their *LineError after the others.
+func (api API) LinesSeq(ctx context.Context) iter.Seq2[BroadbandInfo, error] {
+	return func(yield func(BroadbandInfo, error) bool) {
+		lines, err := api.BroadbandInfoContext(ctx)
+		for _, l := range lines {
+			if !yield(l, nil) {
+				return
+			}
+		}
+		if err == nil {
+			return
+		}
+		var partial *PartialError
+		if !errors.As(err, &partial) {
+			yield(BroadbandInfo{}, err)
+			return
+		}
+		for _, err := range partial.Errs {
+			if !yield(BroadbandInfo{}, err) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package chaos
+
+import "context"
+
+// CredentialProvider supplies credentials for the API from somewhere other
+// than the program's configuration, such as an encrypted file or a secret
+// store. The credentials subpackage has implementations.
+type CredentialProvider interface {
+	Credentials(ctx context.Context) (Auth, error)
+}
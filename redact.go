@@ -0,0 +1,55 @@
+package chaos
+
+import "regexp"
+
+// credentialKeys matches the names of parameters and fields holding
+// credentials.
+const credentialKeys = `(?:account_number|account_password|control_login|control_password|(?i:authorization))`
+
+var (
+	// credentialParamRE matches a credential parameter in form-encoded text
+	// or in key=value log output, such as "control_password=secret". The
+	// value runs to the next parameter or whitespace, and may be quoted. It
+	// may be escaped, as when it's in a JSON string.
+	credentialParamRE = regexp.MustCompile(`(` + credentialKeys + `=)("(?:\\.|[^"\\])*"|(?:\\.|[^&\s"\\])*)`)
+
+	// credentialFieldRE matches a credential field in JSON, such as
+	// `"control_password":"secret"`, or a header in a JSON object of headers,
+	// such as `"Authorization":["Bearer secret"]`.
+	credentialFieldRE = regexp.MustCompile(`("` + credentialKeys + `"\s*:\s*\[?\s*")((?:\\.|[^"\\])*)`)
+
+	// nestedFieldRE matches a credential field in JSON quoted within a JSON
+	// string, such as `\"control_password\":\"secret\"`, whose escapes are
+	// escaped again.
+	nestedFieldRE = regexp.MustCompile(`(\\"` + credentialKeys + `\\"\s*:\s*\[?\s*\\")((?:\\\\(?:\\.|[^\\])|[^"\\])*)`)
+
+	// authHeaderRE matches an Authorization header in an HTTP request, such
+	// as "Authorization: Bearer secret". Credentials in headers don't
+	// contain backslashes or quotes, so they end at an escaped line break.
+	authHeaderRE = regexp.MustCompile(`(?i)(authorization:[ \t]*)([^\r\n"\\]*)`)
+)
+
+// credentialParam reports whether the parameter named k is a credential.
+func credentialParam(k string) bool {
+	switch k {
+	case "account_number", "account_password", "control_login", "control_password":
+		return true
+	}
+	return false
+}
+
+// redacted replaces credentials removed by Redact.
+const redacted = "[REDACTED]"
+
+// Redact returns s with credentials removed, for logging. The values of
+// credential parameters and fields are removed: control_login,
+// control_password, account_number and account_password, and Authorization
+// headers. They're found in form-encoded text, key=value pairs, JSON, and
+// JSON quoted within a JSON string. Credentials which appear anywhere else,
+// such as in free text, aren't removed.
+func Redact(s string) string {
+	for _, re := range []*regexp.Regexp{nestedFieldRE, credentialFieldRE, credentialParamRE, authHeaderRE} {
+		s = re.ReplaceAllString(s, "${1}"+redacted)
+	}
+	return s
+}
@@ -0,0 +1,114 @@
+package chaos
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RetryBudget limits how many retries may be made by all the requests sharing
+// it, so that when the API is failing every caller's retries together don't
+// multiply the load on it.
+type RetryBudget struct {
+	max int
+	per time.Duration
+
+	window time.Time
+	used   int
+}
+
+// budgetMu guards every RetryBudget, so that a retry can be taken from
+// several budgets at once without holding more than one lock.
+var budgetMu sync.Mutex
+
+// NewRetryBudget returns a budget allowing n retries every per. If per is
+// zero, the budget is never refilled, e.g. for the requests made by a single
+// scrape.
+func NewRetryBudget(n int, per time.Duration) *RetryBudget {
+	return &RetryBudget{max: n, per: per}
+}
+
+// takeRetry uses one retry from each of the budgets, reporting whether they
+// all had one left. If any hasn't, none are used. A nil budget is unlimited.
+func takeRetry(budgets ...*RetryBudget) bool {
+	budgetMu.Lock()
+	defer budgetMu.Unlock()
+	now := time.Now()
+	for _, b := range budgets {
+		if b == nil {
+			continue
+		}
+		if b.per > 0 && now.Sub(b.window) >= b.per {
+			b.window = now
+			b.used = 0
+		}
+		if b.used >= b.max {
+			return false
+		}
+	}
+	for i, b := range budgets {
+		if b == nil || containsBudget(budgets[:i], b) {
+			continue
+		}
+		b.used++
+	}
+	return true
+}
+
+// containsBudget reports whether budgets includes b.
+func containsBudget(budgets []*RetryBudget, b *RetryBudget) bool {
+	for _, c := range budgets {
+		if c == b {
+			return true
+		}
+	}
+	return false
+}
+
+type retryBudgetKey struct{}
+
+// WithRetryBudget returns a context whose requests also take their retries
+// from b, as well as from the API's RetryBudget.
+func WithRetryBudget(ctx context.Context, b *RetryBudget) context.Context {
+	return context.WithValue(ctx, retryBudgetKey{}, b)
+}
+
+// retryBackoff is the wait before the first retry. It doubles for each retry
+// after that, up to maxRetryBackoff.
+const (
+	retryBackoff    = 250 * time.Millisecond
+	maxRetryBackoff = 30 * time.Second
+)
+
+// backoff returns the wait before the attempt'th retry.
+func backoff(attempt int) time.Duration {
+	// Shifting much further would overflow.
+	if attempt > 8 {
+		return maxRetryBackoff
+	}
+	return min(retryBackoff<<(attempt-1), maxRetryBackoff)
+}
+
+// shouldRetry reports whether a failed request should be retried for the
+// attempt'th time, taking the retry from the budgets, and waits before the
+// retry.
+func (api API) shouldRetry(ctx context.Context, path string, attempt int) bool {
+	if attempt > api.Retries {
+		return false
+	}
+	if _, ok := LookupEndpoint(path); !ok {
+		return false
+	}
+	ctxBudget, _ := ctx.Value(retryBudgetKey{}).(*RetryBudget)
+	if !takeRetry(ctxBudget, api.RetryBudget) {
+		return false
+	}
+	t := time.NewTimer(backoff(attempt))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,58 @@
+package chaos
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTakeRetryRefusedUsesNeither(t *testing.T) {
+	ctxBudget := NewRetryBudget(1, 0)
+	apiBudget := NewRetryBudget(0, 0)
+	if takeRetry(ctxBudget, apiBudget) {
+		t.Fatal("retry allowed by an empty budget")
+	}
+	if !takeRetry(ctxBudget, nil) {
+		t.Error("refused retry used up the other budget")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt, want := range map[int]time.Duration{
+		1:   250 * time.Millisecond,
+		2:   500 * time.Millisecond,
+		7:   16 * time.Second,
+		8:   30 * time.Second,
+		100: 30 * time.Second,
+	} {
+		if got := backoff(attempt); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+}
+
+func TestUnknownPathSentOnce(t *testing.T) {
+	var requests int32
+	failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	primary := httptest.NewServer(failing)
+	defer primary.Close()
+	fallback := httptest.NewServer(failing)
+	defer fallback.Close()
+
+	api := New(Auth{ControlLogin: "login", ControlPassword: "password"})
+	api.Endpoint = primary.URL
+	api.Fallback = []string{fallback.URL}
+	api.Retries = 2
+
+	if _, err := api.Do("/broadband/topup", nil); !IsRetryable(err) {
+		t.Fatalf("got error %v, want a retryable error", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("unknown path sent %d times, want 1", n)
+	}
+}
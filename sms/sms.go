@@ -0,0 +1,125 @@
+// Package sms sends text messages through Andrews and Arnold's SMS gateway.
+package sms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+)
+
+const defaultEndpoint = "https://sms.aa.net.uk/sms.cgi"
+
+// Client sends messages using the SMS gateway.
+type Client struct {
+	Endpoint string
+	// Credentials, if set, provides the control credentials for each message
+	// instead of those passed to New, so that rotated credentials are
+	// picked up.
+	Credentials chaos.CredentialProvider
+
+	username string
+	password string
+}
+
+// New takes an Auth with control credentials and returns a Client. The SMS
+// gateway doesn't accept account credentials.
+func New(auth chaos.Auth) *Client {
+	return &Client{
+		Endpoint: defaultEndpoint,
+		username: auth.ControlLogin,
+		password: auth.ControlPassword,
+	}
+}
+
+// Message is a text message to send.
+type Message struct {
+	// To is the destination numbers, in international format without the
+	// leading +, e.g. 447700900123.
+	To []string
+	// Text is the message. Long messages are sent as multiple parts.
+	Text string
+	// From is the originator shown to recipients. It's optional, and must be a
+	// number on the account or an alphanumeric name of up to 11 characters.
+	From string
+	// StatusURL, if set, is requested by the gateway with a delivery report
+	// for each destination.
+	StatusURL string
+	// Limit is the most parts a long message may be split into. Zero uses the
+	// gateway's default.
+	Limit int
+}
+
+// Send sends a message, returning the gateway's status message, such as how
+// many messages were queued.
+func (c Client) Send(m Message) (string, error) {
+	return c.SendContext(context.Background(), m)
+}
+
+// SendContext is like Send, but gives up when ctx is done.
+func (c Client) SendContext(ctx context.Context, m Message) (string, error) {
+	username, password := c.username, c.password
+	if c.Credentials != nil {
+		auth, err := c.Credentials.Credentials(ctx)
+		if err != nil {
+			return "", fmt.Errorf("getting credentials: %w", err)
+		}
+		username, password = auth.ControlLogin, auth.ControlPassword
+	}
+	if username == "" || password == "" {
+		return "", errors.New("the SMS gateway requires control credentials")
+	}
+	if len(m.To) == 0 {
+		return "", errors.New("no destination numbers")
+	}
+	form := url.Values{
+		"username": {username},
+		"password": {password},
+		"da":       {strings.Join(m.To, ",")},
+		"ud":       {m.Text},
+	}
+	if m.From != "" {
+		form.Set("oa", m.From)
+	}
+	if m.StatusURL != "" {
+		form.Set("srr", m.StatusURL)
+	}
+	if m.Limit > 0 {
+		form.Set("limit", strconv.Itoa(m.Limit))
+	}
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("error reading response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("bad response code: %d", resp.StatusCode)
+	}
+
+	// The gateway replies with a line of text starting "OK" or "ERR".
+	status := strings.TrimSpace(string(body))
+	if !strings.HasPrefix(status, "OK") {
+		return "", errors.New(status)
+	}
+	return status, nil
+}
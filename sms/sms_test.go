@@ -0,0 +1,42 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	chaos "github.com/jamesog/aaisp-chaos"
+)
+
+// rotatingProvider returns a new password each time it's asked.
+type rotatingProvider struct {
+	n int
+}
+
+func (p *rotatingProvider) Credentials(ctx context.Context) (chaos.Auth, error) {
+	p.n++
+	return chaos.Auth{ControlLogin: "login", ControlPassword: fmt.Sprintf("password%d", p.n)}, nil
+}
+
+func TestSendUsesCurrentCredentials(t *testing.T) {
+	var passwords []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		passwords = append(passwords, r.PostFormValue("password"))
+		fmt.Fprintln(w, "OK: Queued 1")
+	}))
+	defer srv.Close()
+
+	c := New(chaos.Auth{ControlLogin: "login", ControlPassword: "old"})
+	c.Endpoint = srv.URL
+	c.Credentials = new(rotatingProvider)
+	for i := 0; i < 2; i++ {
+		if _, err := c.Send(Message{To: []string{"447700900123"}, Text: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(passwords) != 2 || passwords[0] != "password1" || passwords[1] != "password2" {
+		t.Errorf("sent passwords %q, want those from the provider at the time of each message", passwords)
+	}
+}
@@ -0,0 +1,92 @@
+package chaos
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// TransportOptions configures connections to the API. Zero values use the
+// defaults.
+//
+// Keeping connections open between requests saves a TLS handshake on each
+// request, which adds up for a daemon polling frequently. The timeouts limit
+// each stage of a request separately, within the API's overall Timeout, so
+// that on a high-latency link, for example, the handshake can be given longer
+// without allowing more time for a stalled response.
+type TransportOptions struct {
+	// MaxIdleConns is the most idle connections kept open to each endpoint.
+	// The default is 2.
+	MaxIdleConns int
+	// IdleTimeout is how long an idle connection is kept open. The default
+	// is 90 seconds; a negative value closes connections after each request.
+	IdleTimeout time.Duration
+	// KeepAliveInterval is the time between TCP keep-alive probes on open
+	// connections. The default is 30 seconds; a negative value disables
+	// them.
+	KeepAliveInterval time.Duration
+
+	// DialTimeout limits how long opening a TCP connection may take. The
+	// default is 30 seconds.
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout limits how long the TLS handshake may take. The
+	// default is 10 seconds.
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout limits how long to wait for the response headers
+	// after sending a request. By default only the overall timeout applies.
+	ResponseHeaderTimeout time.Duration
+
+	// LocalAddr, if set, is the local address connections are made from,
+	// such as the address on a particular WAN link. Only endpoint addresses
+	// of the same family are used.
+	LocalAddr net.IP
+	// Interface, if set, is the network interface connections are made
+	// through, such as "ppp0", whatever the routing table says. It's only
+	// supported on Linux, and usually needs CAP_NET_RAW.
+	Interface string
+}
+
+// defaultTransport is shared by APIs without their own Transport, so that
+// connections are reused between requests.
+var defaultTransport = NewTransport(TransportOptions{})
+
+// NewTransport returns a transport for API.Transport which uses HTTP/2 where
+// the endpoint supports it, configured by o.
+func NewTransport(o TransportOptions) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ForceAttemptHTTP2 = true
+	t.MaxIdleConnsPerHost = 2
+	t.IdleConnTimeout = 90 * time.Second
+	t.TLSHandshakeTimeout = 10 * time.Second
+	if o.MaxIdleConns > 0 {
+		t.MaxIdleConnsPerHost = o.MaxIdleConns
+	}
+	switch {
+	case o.IdleTimeout < 0:
+		t.DisableKeepAlives = true
+	case o.IdleTimeout > 0:
+		t.IdleConnTimeout = o.IdleTimeout
+	}
+	if o.TLSHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = o.TLSHandshakeTimeout
+	}
+	if o.ResponseHeaderTimeout > 0 {
+		t.ResponseHeaderTimeout = o.ResponseHeaderTimeout
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if o.DialTimeout > 0 {
+		dialer.Timeout = o.DialTimeout
+	}
+	if o.KeepAliveInterval != 0 {
+		dialer.KeepAlive = o.KeepAliveInterval
+	}
+	if o.LocalAddr != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: o.LocalAddr}
+	}
+	if o.Interface != "" {
+		dialer.Control = bindToDevice(o.Interface)
+	}
+	t.DialContext = dialer.DialContext
+	return t
+}
@@ -0,0 +1,57 @@
+package chaos
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// ErrNotModified is returned instead of a response when the API reports that
+// the data hasn't changed since it was last requested. See API.Validators.
+var ErrNotModified = errors.New("not modified")
+
+// Validators stores the ETag and Last-Modified validators returned by the API
+// so they can be sent with later requests for the same data. The zero value
+// is ready to use.
+type Validators struct {
+	mu sync.Mutex
+	m  map[string]validator
+}
+
+type validator struct {
+	etag         string
+	lastModified string
+}
+
+// set adds the stored validators for key to req as conditional request
+// headers.
+func (v *Validators) set(req *http.Request, key string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	val, ok := v.m[key]
+	if !ok {
+		return
+	}
+	if val.etag != "" {
+		req.Header.Set("If-None-Match", val.etag)
+	}
+	if val.lastModified != "" {
+		req.Header.Set("If-Modified-Since", val.lastModified)
+	}
+}
+
+// store saves the validators in a response header for key. If the response
+// has none, any previously stored validators are forgotten.
+func (v *Validators) store(key string, h http.Header) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	val := validator{etag: h.Get("ETag"), lastModified: h.Get("Last-Modified")}
+	if val == (validator{}) {
+		delete(v.m, key)
+		return
+	}
+	if v.m == nil {
+		v.m = make(map[string]validator)
+	}
+	v.m[key] = val
+}
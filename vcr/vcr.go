@@ -0,0 +1,218 @@
+// Package vcr records CHAOS API responses to a cassette file and replays them
+// later, for developing and testing against realistic data without calling
+// the API.
+//
+// Credentials are removed from requests before they're written to the
+// cassette, but responses are stored as received, so review a cassette before
+// sharing it.
+package vcr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+)
+
+// Mode is whether a Transport records or replays.
+type Mode int
+
+const (
+	// Record sends requests to the API and saves the responses.
+	Record Mode = iota
+	// Replay answers requests from the cassette without calling the API.
+	Replay
+)
+
+// credentialParams are the form parameters removed from recorded requests.
+var credentialParams = []string{"account_number", "account_password", "control_login", "control_password"}
+
+// Interaction is a recorded request and its response.
+type Interaction struct {
+	Method string     `json:"method"`
+	Path   string     `json:"path"`
+	Form   url.Values `json:"form,omitempty"`
+	Status int        `json:"status"`
+	Body   string     `json:"body"`
+}
+
+// Transport is an http.RoundTripper which records to or replays from a
+// cassette file.
+type Transport struct {
+	// Transport makes requests when recording. If nil,
+	// http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	path string
+	mode Mode
+
+	mu           sync.Mutex
+	interactions []Interaction
+	// played records which interactions have been replayed.
+	played []bool
+}
+
+// New returns a Transport using the cassette at path. When recording, new
+// interactions are added to the cassette, which is created if it doesn't
+// exist, and it's saved after each request.
+func New(path string, mode Mode) (*Transport, error) {
+	t := &Transport{path: path, mode: mode}
+	switch mode {
+	case Record:
+		if err := t.load(); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err := t.save(); err != nil {
+			return nil, err
+		}
+	case Replay:
+		if err := t.load(); err != nil {
+			return nil, err
+		}
+		t.played = make([]bool, len(t.interactions))
+	default:
+		return nil, fmt.Errorf("unknown mode %d", mode)
+	}
+	return t, nil
+}
+
+func (t *Transport) load() error {
+	b, err := ioutil.ReadFile(t.path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, &t.interactions); err != nil {
+		return fmt.Errorf("reading cassette %s: %w", t.path, err)
+	}
+	return nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	in, err := newInteraction(req)
+	if err != nil {
+		return nil, err
+	}
+	if t.mode == Replay {
+		return t.replay(req, in)
+	}
+	return t.record(req, in)
+}
+
+// newInteraction returns the request's interaction, without credentials. The
+// request body is restored so it can still be sent.
+func newInteraction(req *http.Request) (Interaction, error) {
+	in := Interaction{Method: req.Method, Path: req.URL.Path}
+	if req.Body == nil {
+		return in, nil
+	}
+	b, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return in, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	form, err := url.ParseQuery(string(b))
+	if err != nil {
+		return in, err
+	}
+	for _, p := range credentialParams {
+		form.Del(p)
+	}
+	if len(form) > 0 {
+		in.Form = form
+	}
+	return in, nil
+}
+
+func (t *Transport) record(req *http.Request, in Interaction) (*http.Response, error) {
+	rt := t.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	in.Status = resp.StatusCode
+	in.Body = string(body)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.interactions = append(t.interactions, in)
+	if err := t.save(); err != nil {
+		return nil, fmt.Errorf("saving cassette: %w", err)
+	}
+	return resp, nil
+}
+
+func (t *Transport) save() error {
+	b, err := json.MarshalIndent(t.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(t.path, b, 0600)
+}
+
+// replay answers the request with the next matching interaction. Matching
+// interactions are replayed in the order they were recorded, and the last one
+// is repeated once they've all been used, so a long-running poller keeps
+// getting responses.
+func (t *Transport) replay(req *http.Request, in Interaction) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	match := -1
+	for i, r := range t.interactions {
+		if !r.matches(in) {
+			continue
+		}
+		match = i
+		if !t.played[i] {
+			break
+		}
+	}
+	if match < 0 {
+		return nil, fmt.Errorf("vcr: no recorded response for %s %s", in.Method, in.Path)
+	}
+	t.played[match] = true
+	r := t.interactions[match]
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status)),
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(r.Body))),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}, nil
+}
+
+func (r Interaction) matches(in Interaction) bool {
+	if r.Method != in.Method || r.Path != in.Path || len(r.Form) != len(in.Form) {
+		return false
+	}
+	for k, v := range r.Form {
+		w := in.Form[k]
+		if len(v) != len(w) {
+			return false
+		}
+		for i := range v {
+			if v[i] != w[i] {
+				return false
+			}
+		}
+	}
+	return true
+}